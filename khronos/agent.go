@@ -11,7 +11,7 @@ import (
 )
 
 type Agent struct {
-	store      *Store
+	store      Storage
 	sched      *Scheduler
 	config     *Configuration
 	ShutdownCh <-chan struct{}
@@ -39,6 +39,11 @@ func (a *Agent) Start() error {
 func (a *Agent) StartServer() {
 	log.Debug("agent.StartServer has been called...")
 	a.store = NewStore(a.config.Backend, a.config.BackendMachines, a.config.Keyspace)
+	if err := a.store.EnsureIndexes(); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("agent.StartServer EnsureIndexes fail.")
+	}
 	a.sched = NewScheduler()
 	a.sched.Agent = a
 
@@ -51,6 +56,9 @@ func (a *Agent) StartServer() {
 			} else {
 				go a.HeartBeat()
 				go a.Schedule()
+				go a.DetectStalls()
+				go a.Janitor()
+				go a.ApplyJobs()
 				conn.Close()
 				return
 			}
@@ -59,6 +67,8 @@ func (a *Agent) StartServer() {
 		}
 	}()
 
+	go listenHTTP(a)
+
 	listenRPC(a)
 }
 
@@ -166,7 +176,8 @@ func (a *Agent) HeartBeat() {
 
 }
 
-func (a *Agent) Do(ex *Execution) {
+// Do sends an execution to a worker node, it reports whether the execution has been acknowledged.
+func (a *Agent) Do(ex *Execution) bool {
 	log.WithFields(log.Fields{
 		"ex": ex,
 	}).Debug("agent.Do has been trigger.")
@@ -182,19 +193,190 @@ func (a *Agent) Do(ex *Execution) {
 			"ex":      ex,
 			"srvAddr": srvAddr,
 		}).Error("agent.Do Not found any worker node.")
+		return false
+	}
+
+	rc := &RPCClient{
+		ServerAddr: srvAddr,
+		agent:      a,
+	}
+
+	// rc.SetXClient(ex)
+	// defer rc.xclient.Close()
 
-	} else {
+	return rc.ExecutionDo(ex)
+}
+
+// DoShards dispatches the shards of a group across the processors,
+// the least busy processor gets the first shard and so on in a round robin.
+// It reports whether one of the shards has been acknowledged.
+func (a *Agent) DoShards(exs []*Execution) bool {
+	if len(exs) == 0 {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"job":    exs[0].JobName,
+		"group":  exs[0].Group,
+		"shards": len(exs),
+	}).Debug("agent.DoShards has been trigger.")
+
+	srvAddr := a.getProcessors(exs[0])
+	if len(srvAddr) == 0 {
+		log.WithFields(log.Fields{
+			"job":   exs[0].JobName,
+			"group": exs[0].Group,
+		}).Error("agent.DoShards Not found any worker node.")
+		return false
+	}
+
+	acked := false
+	for i, ex := range exs {
 		rc := &RPCClient{
-			ServerAddr: srvAddr,
+			ServerAddr: []*Processor{srvAddr[i%len(srvAddr)]},
 			agent:      a,
 		}
+		if rc.ExecutionDo(ex) {
+			acked = true
+		}
+	}
+	return acked
+}
+
+// ReassignShards dispatches again the unfinished shards of a node which has gone,
+// each of them as a new attempt in the same group.
+func (a *Agent) ReassignShards(exs []*Execution) {
+	for _, ex := range exs {
+		log.WithFields(log.Fields{
+			"job":   ex.JobName,
+			"group": ex.Group,
+			"shard": ex.Shard,
+			"node":  ex.NodeName,
+		}).Info("agent.ReassignShards reassign the shard of a gone node.")
+
+		ex.NodeName = ""
+		ex.StartedAt = time.Now()
+		ex.Attempt = ex.Attempt + 1
+		a.DoShards([]*Execution{ex})
+	}
+}
+
+// CancelRunning cancels all of the running executions of a job,
+// they are recorded as failed executions.
+func (a *Agent) CancelRunning(jobName string) {
+	running, err := a.store.GetRunningExecutions(jobName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": jobName,
+			"err": err,
+		}).Error("agent.CancelRunning GetRunningExecutions fail.")
+		return
+	}
+
+	for _, ex := range running {
+		cancelled := false
+		updated, err := a.store.UpdateExecution(jobName, ex.Key(), func(e *Execution) bool {
+			// it may have finished meanwhile
+			if !e.FinishedAt.IsZero() {
+				return false
+			}
+			e.FinishedAt = time.Now()
+			e.Success = false
+			e.Output = []byte("cancelled: replaced by a new execution")
+			cancelled = true
+			return true
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job": jobName,
+				"err": err,
+			}).Error("agent.CancelRunning UpdateExecution fail.")
+			continue
+		}
+		if !cancelled {
+			continue
+		}
 
-		// rc.SetXClient(ex)
-		// defer rc.xclient.Close()
+		a.cancelOnWorker(updated)
+		go updated.DecCounter(updated.NodeName, "undo")
+		go updated.DecCounter(updated.NodeName, updated.Tags["type"])
 
-		rc.ExecutionDo(ex)
+		a.countFailure(updated)
+		a.releaseSlot(updated)
 	}
+}
 
+// releaseSlot releases the slot of the group of an execution once none of the group is running.
+func (a *Agent) releaseSlot(ex *Execution) {
+	running, err := queryAll(a.store, ExecutionQuery{Job: ex.JobName, Group: ex.Group, Status: StateRunning})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": ex.JobName,
+			"err": err,
+		}).Error("agent.releaseSlot QueryExecutions fail.")
+		return
+	}
+	if len(running) > 0 {
+		return
+	}
+
+	if err := a.store.ReleaseSlot(ex.JobName, ex.Group); err != nil {
+		log.WithFields(log.Fields{
+			"job":   ex.JobName,
+			"group": ex.Group,
+			"err":   err,
+		}).Error("agent.releaseSlot ReleaseSlot fail.")
+	}
+}
+
+// cancelOnWorker asks the processor running the execution to stop it.
+func (a *Agent) cancelOnWorker(ex *Execution) {
+	p, err := a.store.GetProcessorByNodeName(ex.Application, ex.NodeName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job":  ex.JobName,
+			"node": ex.NodeName,
+			"err":  err,
+		}).Error("agent.cancelOnWorker not found the processor of the execution.")
+		return
+	}
+
+	rc := &RPCClient{
+		ServerAddr: []*Processor{p},
+		agent:      a,
+	}
+	rc.ExecutionCancel(ex)
+}
+
+// RunQueued runs the deferred schedule of a job if there is one.
+func (a *Agent) RunQueued(jobName string) {
+	scheduled, err := a.store.DequeueJob(jobName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": jobName,
+			"err": err,
+		}).Error("agent.RunQueued DequeueJob fail.")
+		return
+	}
+	if scheduled.IsZero() {
+		return
+	}
+
+	job, err := a.store.GetJob(jobName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": jobName,
+			"err": err,
+		}).Error("agent.RunQueued GetJob fail.")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"job": jobName,
+	}).Debug("agent.RunQueued run the deferred schedule.")
+
+	job.Agent = a
+	job.fire(scheduled, 0)
 }
 
 func (a *Agent) GetWorkerRPCAddr(ex *Execution, rebalance string) []*Processor {
@@ -202,49 +384,147 @@ func (a *Agent) GetWorkerRPCAddr(ex *Execution, rebalance string) []*Processor {
 		"ex": ex,
 	}).Debug("agent.getWorkerRPCAddr has been called.")
 
-	srvAddr, err := a.store.GetProcessorsByApp(ex.Application)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"Application": ex.Application,
-			"err":         err,
-		}).Error("agent.getWorkerRPCAddr don't got any processor.")
+	srvAddr := a.getProcessors(ex)
+	if srvAddr == nil {
 		return nil
 	}
 
-	srvAddr = ex.CheckCounter(srvAddr)
+	n := 1
+	switch ex.Target {
+	case TargetAll:
+		return srvAddr
+	case TargetN:
+		n = ex.TargetCount
+	}
 
-	if ex.Concurrency == "forbid" && len(srvAddr) > 0 {
+	if n >= len(srvAddr) {
+		if n > len(srvAddr) {
+			log.WithFields(log.Fields{
+				"job":        ex.JobName,
+				"target":     n,
+				"processors": len(srvAddr),
+			}).Warn("agent.GetWorkerRPCAddr not enough processors for the target")
+		}
+		return srvAddr
+	}
 
-		switch rebalance {
-		case "random":
-			rand.Seed(time.Now().Unix())
-			idx := rand.Intn(len(srvAddr))
-			srvAddrRand := make([]*Processor, 0)
+	switch rebalance {
+	case "random":
+		rand.Seed(time.Now().Unix())
+		srvAddrRand := make([]*Processor, 0)
+		for _, idx := range rand.Perm(len(srvAddr))[:n] {
 			srvAddrRand = append(srvAddrRand, srvAddr[idx])
+		}
 
-			log.WithFields(log.Fields{
-				"srvAddr": srvAddr[idx],
-			}).Debug("agent.GetWorkerRPCAddr random selection")
+		log.WithFields(log.Fields{
+			"srvAddr": srvAddrRand,
+		}).Debug("agent.GetWorkerRPCAddr random selection")
+
+		return srvAddrRand
+
+	// return the processors that being the least amount of undo of processor
+	default:
+		log.WithFields(log.Fields{
+			"srvAddr": srvAddr[:n],
+		}).Debug("agent.GetWorkerRPCAddr minimum selection by undone")
 
-			return srvAddrRand
+		return srvAddr[:n]
 
-		// return a processor that being the least amount of undo of processor
-		default:
-			idx := 0
-			srvAddrDef := make([]*Processor, 0)
-			srvAddrDef = append(srvAddrDef, srvAddr[idx])
+	}
+}
+
+// Blackout returns the calendar suppressing the schedule of a job at the given time, nil if there is none.
+func (a *Agent) Blackout(j *Job, t time.Time) *Calendar {
+	calendars, err := a.store.GetCalendars()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+			"err": err,
+		}).Error("agent.Blackout GetCalendars fail.")
+		return nil
+	}
 
+	for _, c := range calendars {
+		if c.AppliesTo(j) && c.Contains(t) {
+			return c
+		}
+	}
+	return nil
+}
+
+// UpdateTemplateInstances instantiates the instances of a template again with their parameters,
+// it returns the number of instances updated, the instances made invalid by the template are left as they are.
+func (a *Agent) UpdateTemplateInstances(t *Template) int {
+	instances, err := a.store.GetTemplateInstances(t.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"template": t.Name,
+			"err":      err,
+		}).Error("agent.UpdateTemplateInstances GetTemplateInstances fail.")
+		return 0
+	}
+
+	updated := 0
+	for _, ij := range instances {
+		// a managed job is changed by its source only, see MakeJob
+		if ij.ManagedBy != "" {
 			log.WithFields(log.Fields{
-				"srvAddr": srvAddr[idx],
-			}).Debug("agent.GetWorkerRPCAddr minimum selection by undone")
+				"template":   t.Name,
+				"job":        ij.Name,
+				"managed_by": ij.ManagedBy,
+			}).Info("agent.UpdateTemplateInstances skip a managed instance.")
+			continue
+		}
 
-			return srvAddrDef
+		job, err := t.Instantiate(ij.Name, ij.TemplateParams)
+		if err == nil {
+			err = job.Validate()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"template": t.Name,
+				"job":      ij.Name,
+				"err":      err,
+			}).Error("agent.UpdateTemplateInstances invalid instance.")
+			continue
+		}
+		// an instance is disabled on its own
+		job.Disabled = ij.Disabled
 
+		if err := a.store.SetJob(job); err != nil {
+			log.WithFields(log.Fields{
+				"template": t.Name,
+				"job":      ij.Name,
+				"err":      err,
+			}).Error("agent.UpdateTemplateInstances SetJob fail.")
+			continue
 		}
+		updated++
+	}
+	return updated
+}
+
+// getProcessors returns the processors able to run the execution sorted by undone.
+func (a *Agent) getProcessors(ex *Execution) []*Processor {
+	srvAddr, err := a.store.GetProcessorsByApp(ex.Application)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Application": ex.Application,
+			"err":         err,
+		}).Error("agent.getWorkerRPCAddr don't got any processor.")
+		return nil
+	}
 
+	srvAddr = FilterProcessors(srvAddr, ex.NodeSelector)
+	if len(srvAddr) == 0 {
+		log.WithFields(log.Fields{
+			"Application":  ex.Application,
+			"nodeSelector": ex.NodeSelector,
+		}).Error("agent.getWorkerRPCAddr don't got any processor matching the node selector.")
+		return nil
 	}
 
-	return srvAddr
+	return ex.CheckCounter(srvAddr)
 }
 
 func (a *Agent) Leave() error {
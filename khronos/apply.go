@@ -0,0 +1,229 @@
+package khronos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultApplySource is the source of the jobs applied from files by default.
+	DefaultApplySource = "apply"
+
+	// DefaultApplyInterval is how often an agent applies the job files by default.
+	DefaultApplyInterval = time.Minute
+)
+
+// ReadJobFiles reads the jobs defined in the .json, .yaml and .yml files of a directory,
+// a file defines a job or a list of jobs.
+func ReadJobFiles(dir string) ([]*Job, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0)
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defined, err := decodeJobs(data, FormatOf(path))
+		if err != nil {
+			return nil, fmt.Errorf("apply: %s: %s", path, err)
+		}
+		jobs = append(jobs, defined...)
+	}
+	return jobs, nil
+}
+
+// decodeJobs reads a job or a list of jobs in the format.
+func decodeJobs(data []byte, format string) ([]*Job, error) {
+	if format == FormatYAML {
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(fromYAML(v)); err != nil {
+			return nil, err
+		}
+	}
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		jobs := make([]*Job, 0)
+		if err := json.Unmarshal(data, &jobs); err != nil {
+			return nil, err
+		}
+		return jobs, nil
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return []*Job{&job}, nil
+}
+
+// PlanApply compares the jobs defined by a source with the jobs of the store, the jobs are marked as managed
+// by the source. With prune the jobs of the source which aren't defined anymore are deleted.
+// The jobs owned by another source, or created otherwise, are refused.
+func PlanApply(s Storage, jobs []*Job, source string, prune bool) (*ImportPlan, error) {
+	if source == "" {
+		return nil, fmt.Errorf("apply: the source is required")
+	}
+
+	errs := make([]string, 0)
+	defined := make(map[string]bool)
+	for _, j := range jobs {
+		if defined[j.Name] {
+			errs = append(errs, fmt.Sprintf("job '%s' is defined twice", j.Name))
+		}
+		defined[j.Name] = true
+		if err := j.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("job '%s': %s", j.Name, err))
+		}
+	}
+
+	current, err := s.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Job)
+	for _, j := range current {
+		byName[j.Name] = j
+		if !defined[j.Name] {
+			continue
+		}
+		switch j.ManagedBy {
+		case source:
+		case "":
+			errs = append(errs, fmt.Sprintf("job '%s' isn't managed by '%s'", j.Name, source))
+		default:
+			errs = append(errs, fmt.Sprintf("job '%s' is managed by '%s'", j.Name, j.ManagedBy))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("apply: %s", strings.Join(errs, "; "))
+	}
+
+	plan := &ImportPlan{Changes: make([]*Change, 0)}
+	for _, j := range jobs {
+		j := j
+		j.ManagedBy = source
+		j.Metadata = JobMetaData{}
+		apply := func(s Storage) error { return s.SetJob(j) }
+
+		prev, ok := byName[j.Name]
+		if !ok {
+			plan.Changes = append(plan.Changes, &Change{Action: ActionCreate, Kind: "job", Name: j.Name, apply: apply})
+			continue
+		}
+
+		// a done job stays done unless it's rescheduled, see Store.SetJob
+		if prev.Schedule == j.Schedule && prev.MaxRuns == j.MaxRuns {
+			j.IsDone = prev.IsDone
+		}
+		prev.Metadata = JobMetaData{}
+		prevJSON, _ := json.Marshal(prev)
+		jobJSON, _ := json.Marshal(j)
+		if bytes.Equal(prevJSON, jobJSON) {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, &Change{Action: ActionUpdate, Kind: "job", Name: j.Name, Fields: diffFields(prevJSON, jobJSON), apply: apply})
+	}
+
+	if prune {
+		names := make([]string, 0)
+		for _, j := range current {
+			if j.ManagedBy == source && !defined[j.Name] {
+				names = append(names, j.Name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			name := name
+			plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, Kind: "job", Name: name, apply: func(s Storage) error {
+				if _, err := s.DeleteJob(name); err != nil {
+					return err
+				}
+				return s.DeleteExecutions(name)
+			}})
+		}
+	}
+	return plan, nil
+}
+
+// ApplyJobs applies the job files of the directory of the configuration every apply interval.
+// The agents don't coordinate the applies, so the directory is configured on a single agent of the cluster.
+func (a *Agent) ApplyJobs() {
+	if a.config.ApplyDir == "" {
+		return
+	}
+	interval := a.config.ApplyInterval
+	if interval <= 0 {
+		interval = DefaultApplyInterval
+	}
+
+	for {
+		a.applyJobFiles()
+		time.Sleep(interval)
+	}
+}
+
+func (a *Agent) applyJobFiles() {
+	source := a.config.ApplySource
+	if source == "" {
+		source = DefaultApplySource
+	}
+
+	jobs, err := ReadJobFiles(a.config.ApplyDir)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir": a.config.ApplyDir,
+			"err": err,
+		}).Error("agent.applyJobFiles ReadJobFiles fail.")
+		return
+	}
+
+	plan, err := PlanApply(a.store, jobs, source, a.config.ApplyPrune)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir": a.config.ApplyDir,
+			"err": err,
+		}).Error("agent.applyJobFiles PlanApply fail.")
+		return
+	}
+	if len(plan.Changes) == 0 {
+		return
+	}
+
+	for _, c := range plan.Changes {
+		log.WithFields(log.Fields{
+			"source": source,
+			"change": c.String(),
+		}).Info("agent.applyJobFiles applying.")
+	}
+	if err := plan.Apply(a.store); err != nil {
+		log.WithFields(log.Fields{
+			"dir": a.config.ApplyDir,
+			"err": err,
+		}).Error("agent.applyJobFiles Apply fail.")
+	}
+}
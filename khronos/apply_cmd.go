@@ -0,0 +1,89 @@
+package khronos
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// ApplyCommand reconciles the jobs of the keyspace with the job files of a directory
+type ApplyCommand struct {
+	Ui cli.Ui
+}
+
+// Help returns apply command usage to the CLI.
+func (c *ApplyCommand) Help() string {
+	helpText := `
+Usage: khronos apply [options]
+	Create and update the jobs defined in the .json, .yaml and .yml files of a directory,
+	a file defines a job or a list of jobs. The jobs are managed by the source of the files,
+	the jobs managed by another source, or created otherwise, are refused.
+	With the boltdb backend, the agent using the file must be stopped first.
+Options:
+  -e=prod                         The environment of the keyspace (local, dev, sit, prod)
+  -f=jobs/                        The directory of the job files.
+  -source=apply                   The source managing the jobs of the files, default to apply.
+  -prune                          Delete the jobs of the source which aren't in the files anymore.
+  -dry-run                        Print the plan without applying it.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis returns the purpose of the command for the CLI
+func (c *ApplyCommand) Synopsis() string {
+	return "Apply the job files of a directory"
+}
+
+func (c *ApplyCommand) Run(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	fs.Usage = func() { c.Ui.Output(c.Help()) }
+	env := fs.String("e", "", "")
+	dir := fs.String("f", "", "")
+	source := fs.String("source", DefaultApplySource, "")
+	prune := fs.Bool("prune", false, "")
+	dryRun := fs.Bool("dry-run", false, "")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *dir == "" {
+		c.Ui.Error("The directory of the job files is required (-f)")
+		return 1
+	}
+
+	config, err := commandConfig(*env, "")
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	jobs, err := ReadJobFiles(*dir)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	s := NewStore(config.Backend, config.BackendMachines, config.Keyspace)
+	plan, err := PlanApply(s, jobs, *source, *prune)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Applying %d jobs of %s to %s as %s", len(jobs), *dir, config.Keyspace, *source))
+	for _, change := range plan.Changes {
+		c.Ui.Output(change.String())
+	}
+	c.Ui.Output(plan.Summary())
+	if *dryRun || len(plan.Changes) == 0 {
+		return 0
+	}
+
+	if err := plan.Apply(s); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Output("Applied.")
+	return 0
+}
@@ -0,0 +1,114 @@
+package khronos
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go test -v -run=TestPlanApply
+func TestPlanApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khronos-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spiders := `
+- name: spider-eth
+  schedule: "@every 5s"
+  job_type: rpc
+  Application: spider
+  payload:
+    coin: eth
+- name: spider-btc
+  schedule: "@every 5s"
+  job_type: rpc
+  Application: spider
+`
+	parser := `{"name": "parser", "schedule": "@every 1m", "job_type": "rpc", "Application": "parser"}`
+	ioutil.WriteFile(filepath.Join(dir, "spiders.yaml"), []byte(spiders), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "parser.json"), []byte(parser), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# jobs"), 0644)
+
+	jobs, err := ReadJobFiles(dir)
+	if err != nil {
+		t.Fatalf("error reading job files: %s", err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("expected 3 jobs got %d", len(jobs))
+	}
+
+	// a job of the same name created otherwise
+	s := createTestStore()
+	s.SetJob(&Job{Name: "parser", Schedule: "@every 1m", JobType: "rpc", Application: "parser"})
+	if _, err := PlanApply(s, jobs, "git", false); err == nil {
+		t.Fatalf("expected the unmanaged job refused")
+	}
+	s.DeleteJob("parser")
+
+	plan, err := PlanApply(s, jobs, "git", false)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "3 to create, 0 to update, 0 to delete, 0 unchanged" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+	if err := plan.Apply(s); err != nil {
+		t.Fatalf("error applying: %s", err)
+	}
+	if job, _ := s.GetJob("spider-eth"); job.ManagedBy != "git" || job.Payload["coin"] != "eth" {
+		t.Fatalf("unexpected applied job %+v", job)
+	}
+
+	// another source can't take the jobs over
+	if _, err := PlanApply(s, jobs[:1], "ops", false); err == nil {
+		t.Fatalf("expected the job of another source refused")
+	}
+
+	// the RPC can't change a managed job
+	r := &RPCServer{agent: createTestAgent(s)}
+	var reply JobReply
+	r.MakeJob(context.Background(), &Job{Name: "parser", Schedule: "@every 5m", JobType: "rpc", Application: "parser"}, &reply)
+	if reply.Success || len(reply.Errors) != 1 || reply.Errors[0].Field != "managed_by" {
+		t.Fatalf("expected the managed job refused got %+v", reply)
+	}
+	// nor can it set the source of a job
+	reply = JobReply{}
+	r.MakeJob(context.Background(), &Job{Name: "loader", Schedule: "@every 5m", JobType: "rpc", Application: "loader", ManagedBy: "git"}, &reply)
+	if reply.Success || len(reply.Errors) != 1 || reply.Errors[0].Field != "managed_by" {
+		t.Fatalf("expected the source set by the RPC refused got %+v", reply)
+	}
+	if _, err := s.GetJob("loader"); err == nil {
+		t.Fatalf("expected the job not stored")
+	}
+
+	// the spiders are rescheduled and the parser is removed from the files
+	os.Remove(filepath.Join(dir, "parser.json"))
+	jobs, _ = ReadJobFiles(dir)
+	jobs[0].Schedule = "@every 10s"
+
+	plan, err = PlanApply(s, jobs, "git", false)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "0 to create, 1 to update, 0 to delete, 1 unchanged" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+
+	plan, err = PlanApply(s, jobs, "git", true)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "0 to create, 1 to update, 1 to delete, 1 unchanged" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+	if err := plan.Apply(s); err != nil {
+		t.Fatalf("error applying: %s", err)
+	}
+	if _, err := s.GetJob("parser"); err == nil {
+		t.Fatalf("expected the parser pruned")
+	}
+}
@@ -0,0 +1,468 @@
+package khronos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ArchiveVersion is the version of the archives exported, the archives of a later version can't be imported.
+const ArchiveVersion = 1
+
+// RedactedSecret replaces the webhook secrets of an archive exported without its secrets,
+// an import keeps the secret of the keyspace in its place.
+const RedactedSecret = "<redacted>"
+
+// Formats of an archive.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Strategies of an import.
+const (
+	// ImportMerge creates and updates the definitions of the archive, leaving the others as they are.
+	ImportMerge = "merge"
+	// ImportReplace makes the keyspace hold the definitions of the archive only.
+	ImportReplace = "replace"
+)
+
+// Actions of the changes of an import.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Archive holds the definitions of a keyspace, to be backed up or moved to another keyspace.
+type Archive struct {
+	Version int `json:"version"`
+
+	// the keyspace exported
+	Keyspace string `json:"keyspace"`
+
+	ExportedAt time.Time `json:"exported_at"`
+
+	// the jobs without their metadata
+	Jobs []*Job `json:"jobs"`
+
+	Templates []*Template `json:"templates"`
+
+	Calendars []*Calendar `json:"calendars"`
+
+	// nil unless the processors have been exported
+	Processors []*Processor `json:"processors"`
+}
+
+// Change is a definition created, updated or deleted by an import.
+type Change struct {
+	Action string `json:"action"`
+
+	// job, template, calendar or processor
+	Kind string `json:"kind"`
+
+	Name string `json:"name"`
+
+	// the fields updated
+	Fields []string `json:"fields"`
+
+	apply func(s Storage) error
+}
+
+// String formats the change as a line of a diff, e.g. "~ job spider (schedule, payload)".
+func (c *Change) String() string {
+	sign := map[string]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[c.Action]
+	line := fmt.Sprintf("%s %s %s", sign, c.Kind, c.Name)
+	if len(c.Fields) > 0 {
+		line += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return line
+}
+
+// ImportPlan is the changes of an import in the order they're applied.
+type ImportPlan struct {
+	Changes []*Change `json:"changes"`
+
+	// the number of definitions of the archive already in the keyspace
+	Unchanged int `json:"unchanged"`
+
+	// the jobs and the templates with a redacted secret which the keyspace doesn't have,
+	// e.g. "job deploy", they're imported without secret
+	LostSecrets []string `json:"lost_secrets"`
+}
+
+// Summary counts the changes of the plan.
+func (p *ImportPlan) Summary() string {
+	counts := make(map[string]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d to delete, %d unchanged",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], p.Unchanged)
+}
+
+// Apply makes the changes of the plan, it stops at the first failure.
+func (p *ImportPlan) Apply(s Storage) error {
+	for _, c := range p.Changes {
+		if err := c.apply(s); err != nil {
+			return fmt.Errorf("import: %s %s '%s' failed: %s", c.Action, c.Kind, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Export reads the definitions of the keyspace of the store into an archive,
+// the webhook secrets are redacted unless they're exported too.
+func Export(s Storage, keyspace string, processors bool, secrets bool) (*Archive, error) {
+	a := &Archive{
+		Version:    ArchiveVersion,
+		Keyspace:   keyspace,
+		ExportedAt: time.Now(),
+	}
+
+	var err error
+	if a.Jobs, err = s.GetJobs(); err != nil {
+		return nil, err
+	}
+	for _, j := range a.Jobs {
+		j.Metadata = JobMetaData{}
+		if !secrets {
+			j.WebhookSecret = redact(j.WebhookSecret)
+		}
+	}
+	if a.Templates, err = s.GetTemplates(); err != nil {
+		return nil, err
+	}
+	for _, t := range a.Templates {
+		if !secrets {
+			t.Job.WebhookSecret = redact(t.Job.WebhookSecret)
+		}
+	}
+	if a.Calendars, err = s.GetCalendars(); err != nil {
+		return nil, err
+	}
+	if processors {
+		if a.Processors, err = s.GetProcessors(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Encode writes the archive in the format.
+func (a *Archive) Encode(format string) ([]byte, error) {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		return append(data, '\n'), nil
+	case FormatYAML:
+		// by the JSON names of the fields
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	}
+	return nil, fmt.Errorf("archive: unknown format '%s'", format)
+}
+
+// DecodeArchive reads an archive in the format.
+func DecodeArchive(data []byte, format string) (*Archive, error) {
+	switch format {
+	case FormatJSON:
+	case FormatYAML:
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(fromYAML(v)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("archive: unknown format '%s'", format)
+	}
+
+	var a Archive
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		return nil, fmt.Errorf("archive: unsupported version %d, expected at most %d", a.Version, ArchiveVersion)
+	}
+	return &a, nil
+}
+
+// FormatOf returns the format of an archive by the extension of its file, JSON by default.
+func FormatOf(path string) string {
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// Validate checks the definitions of the archive before they're imported.
+func (a *Archive) Validate() error {
+	errs := make([]string, 0)
+	seen := make(map[string]bool)
+	unique := func(kind string, name string) {
+		if seen[kind+"/"+name] {
+			errs = append(errs, fmt.Sprintf("%s '%s' is defined twice", kind, name))
+		}
+		seen[kind+"/"+name] = true
+	}
+
+	for _, j := range a.Jobs {
+		unique("job", j.Name)
+		if err := j.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("job '%s': %s", j.Name, err))
+		}
+	}
+	for _, t := range a.Templates {
+		unique("template", t.Name)
+		if err := t.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, c := range a.Calendars {
+		unique("calendar", c.Name)
+		if err := c.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, p := range a.Processors {
+		unique("processor", processorName(p))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("archive: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Plan compares the archive with the keyspace of the store, the changes are applied by ImportPlan.Apply.
+// With ImportReplace the processors are replaced only if the archive holds them.
+func (a *Archive) Plan(s Storage, strategy string) (*ImportPlan, error) {
+	if strategy != ImportMerge && strategy != ImportReplace {
+		return nil, fmt.Errorf("import: unknown strategy '%s', expected %s or %s", strategy, ImportMerge, ImportReplace)
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	jobs, err := s.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := s.GetTemplates()
+	if err != nil {
+		return nil, err
+	}
+	calendars, err := s.GetCalendars()
+	if err != nil {
+		return nil, err
+	}
+	var processors []*Processor
+	if a.Processors != nil {
+		if processors, err = s.GetProcessors(); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &ImportPlan{Changes: make([]*Change, 0), LostSecrets: make([]string, 0)}
+	current := make(map[string][]byte)
+	// the secrets of the keyspace in place of the redacted ones, a new definition has none
+	secrets := make(map[string]string)
+	for _, j := range jobs {
+		j.Metadata = JobMetaData{}
+		current["job/"+j.Name], _ = json.Marshal(j)
+		secrets["job/"+j.Name] = j.WebhookSecret
+	}
+	for _, t := range templates {
+		current["template/"+t.Name], _ = json.Marshal(t)
+		secrets["template/"+t.Name] = t.Job.WebhookSecret
+	}
+	for _, c := range calendars {
+		current["calendar/"+c.Name], _ = json.Marshal(c)
+	}
+	for _, p := range processors {
+		current["processor/"+processorName(p)], _ = json.Marshal(p)
+	}
+
+	// the calendars and the templates first, they're referenced by the jobs
+	put := func(kind string, name string, v interface{}, apply func(s Storage) error) {
+		data, _ := json.Marshal(v)
+		prev, ok := current[kind+"/"+name]
+		switch {
+		case !ok:
+			plan.Changes = append(plan.Changes, &Change{Action: ActionCreate, Kind: kind, Name: name, apply: apply})
+		case bytes.Equal(prev, data):
+			plan.Unchanged++
+		default:
+			plan.Changes = append(plan.Changes, &Change{Action: ActionUpdate, Kind: kind, Name: name, Fields: diffFields(prev, data), apply: apply})
+		}
+	}
+	for _, c := range a.Calendars {
+		c := c
+		put("calendar", c.Name, c, func(s Storage) error { return s.SetCalendar(c) })
+	}
+	for _, t := range a.Templates {
+		t := t
+		if t.Job.WebhookSecret == RedactedSecret {
+			t.Job.WebhookSecret = secrets["template/"+t.Name]
+			if t.Job.WebhookSecret == "" {
+				plan.LostSecrets = append(plan.LostSecrets, "template "+t.Name)
+			}
+		}
+		put("template", t.Name, t, func(s Storage) error { return s.SetTemplate(t) })
+	}
+	for _, j := range a.Jobs {
+		j := j
+		j.Metadata = JobMetaData{}
+		if j.WebhookSecret == RedactedSecret {
+			j.WebhookSecret = secrets["job/"+j.Name]
+			if j.WebhookSecret == "" {
+				plan.LostSecrets = append(plan.LostSecrets, "job "+j.Name)
+			}
+		}
+		put("job", j.Name, j, func(s Storage) error { return s.SetJob(j) })
+	}
+	for _, p := range a.Processors {
+		p := p
+		put("processor", processorName(p), p, func(s Storage) error { return s.SetProcessor(p) })
+	}
+
+	if strategy == ImportReplace {
+		// the jobs first, they reference the calendars and the templates
+		for _, j := range jobs {
+			if !a.hasDefinition("job", j.Name) {
+				name := j.Name
+				plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, Kind: "job", Name: name, apply: func(s Storage) error {
+					if _, err := s.DeleteJob(name); err != nil {
+						return err
+					}
+					return s.DeleteExecutions(name)
+				}})
+			}
+		}
+		for _, t := range templates {
+			if !a.hasDefinition("template", t.Name) {
+				name := t.Name
+				plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, Kind: "template", Name: name, apply: func(s Storage) error {
+					_, err := s.DeleteTemplate(name)
+					return err
+				}})
+			}
+		}
+		for _, c := range calendars {
+			if !a.hasDefinition("calendar", c.Name) {
+				name := c.Name
+				plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, Kind: "calendar", Name: name, apply: func(s Storage) error {
+					_, err := s.DeleteCalendar(name)
+					return err
+				}})
+			}
+		}
+		for _, p := range processors {
+			if !a.hasDefinition("processor", processorName(p)) {
+				app, addr := p.Application, fmt.Sprintf("%s:%d", p.IP, p.Port)
+				plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, Kind: "processor", Name: processorName(p), apply: func(s Storage) error {
+					_, err := s.DeleteProcessor(app, addr)
+					return err
+				}})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// redact hides a secret unless it's empty.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedSecret
+}
+
+func (a *Archive) hasDefinition(kind string, name string) bool {
+	switch kind {
+	case "job":
+		for _, j := range a.Jobs {
+			if j.Name == name {
+				return true
+			}
+		}
+	case "template":
+		for _, t := range a.Templates {
+			if t.Name == name {
+				return true
+			}
+		}
+	case "calendar":
+		for _, c := range a.Calendars {
+			if c.Name == name {
+				return true
+			}
+		}
+	case "processor":
+		for _, p := range a.Processors {
+			if processorName(p) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// processorName identifies a processor as it's stored, e.g. "spider/127.0.0.1:9009".
+func processorName(p *Processor) string {
+	return fmt.Sprintf("%s/%s:%d", p.Application, p.IP, p.Port)
+}
+
+// diffFields returns the fields of the JSON objects whose values differ.
+func diffFields(prev []byte, next []byte) []string {
+	var a, b map[string]json.RawMessage
+	json.Unmarshal(prev, &a)
+	json.Unmarshal(next, &b)
+
+	fields := make([]string, 0)
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !bytes.Equal(v, w) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// fromYAML turns the maps of a YAML document into maps of strings to be marshaled in JSON.
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = fromYAML(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromYAML(e)
+		}
+		return v
+	}
+	return v
+}
@@ -0,0 +1,198 @@
+package khronos
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// ExportCommand exports the definitions of the keyspace to an archive
+type ExportCommand struct {
+	Ui cli.Ui
+}
+
+// Help returns export command usage to the CLI.
+func (c *ExportCommand) Help() string {
+	helpText := `
+Usage: khronos export [options]
+	Export the jobs, templates and calendars of the keyspace to a versioned archive
+	With the boltdb backend, the agent using the file must be stopped first.
+Options:
+  -e=prod                         The environment of the keyspace (local, dev, sit, prod)
+  -o=jobs.yaml                    The file of the archive, the format is yaml for .yaml and .yml files,
+                                  json otherwise. Default to the standard output.
+  -format=json                    The format of the archive (json, yaml), overrides the extension.
+  -processors                     Export the processors too.
+  -secrets                        Export the webhook secrets too, they're redacted by default.
+  -keyspace=/khronos              The keyspace to export. Default to the keyspace of the environment.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis returns the purpose of the command for the CLI
+func (c *ExportCommand) Synopsis() string {
+	return "Export the keyspace to an archive"
+}
+
+func (c *ExportCommand) Run(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() { c.Ui.Output(c.Help()) }
+	env := fs.String("e", "", "")
+	out := fs.String("o", "", "")
+	format := fs.String("format", "", "")
+	processors := fs.Bool("processors", false, "")
+	secrets := fs.Bool("secrets", false, "")
+	keyspace := fs.String("keyspace", "", "")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	config, err := commandConfig(*env, *keyspace)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if *format == "" {
+		*format = FormatOf(*out)
+	}
+
+	s := NewStore(config.Backend, config.BackendMachines, config.Keyspace)
+	archive, err := Export(s, config.Keyspace, *processors, *secrets)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error exporting %s: %s", config.Keyspace, err))
+		return 1
+	}
+	data, err := archive.Encode(*format)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Output(fmt.Sprintf("Exported %d jobs, %d templates, %d calendars and %d processors of %s to %s",
+		len(archive.Jobs), len(archive.Templates), len(archive.Calendars), len(archive.Processors), config.Keyspace, *out))
+	return 0
+}
+
+// ImportCommand imports the definitions of an archive to the keyspace
+type ImportCommand struct {
+	Ui cli.Ui
+}
+
+// Help returns import command usage to the CLI.
+func (c *ImportCommand) Help() string {
+	helpText := `
+Usage: khronos import [options]
+	Import the jobs, templates, calendars and processors of an archive to the keyspace
+	With the boltdb backend, the agent using the file must be stopped first.
+Options:
+  -e=prod                         The environment of the keyspace (local, dev, sit, prod)
+  -i=jobs.yaml                    The file of the archive, the format is yaml for .yaml and .yml files,
+                                  json otherwise. Default to the standard input.
+  -format=json                    The format of the archive (json, yaml), overrides the extension.
+  -strategy=merge                 merge (default): Create and update the definitions of the archive.
+                                  replace: Delete the definitions which aren't in the archive too,
+                                  with the executions of the jobs deleted.
+  -keyspace=/khronos              Import to this keyspace rather than the keyspace of the environment.
+  -dry-run                        Print the changes without making them.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Synopsis returns the purpose of the command for the CLI
+func (c *ImportCommand) Synopsis() string {
+	return "Import an archive to the keyspace"
+}
+
+func (c *ImportCommand) Run(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() { c.Ui.Output(c.Help()) }
+	env := fs.String("e", "", "")
+	in := fs.String("i", "", "")
+	format := fs.String("format", "", "")
+	strategy := fs.String("strategy", ImportMerge, "")
+	keyspace := fs.String("keyspace", "", "")
+	dryRun := fs.Bool("dry-run", false, "")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	config, err := commandConfig(*env, *keyspace)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if *format == "" {
+		*format = FormatOf(*in)
+	}
+
+	var data []byte
+	if *in == "" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	archive, err := DecodeArchive(data, *format)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	s := NewStore(config.Backend, config.BackendMachines, config.Keyspace)
+	plan, err := archive.Plan(s, *strategy)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Importing the archive of %s exported at %s to %s by %s",
+		archive.Keyspace, archive.ExportedAt.Format("2006-01-02 15:04:05"), config.Keyspace, *strategy))
+	for _, change := range plan.Changes {
+		c.Ui.Output(change.String())
+	}
+	c.Ui.Output(plan.Summary())
+	for _, name := range plan.LostSecrets {
+		c.Ui.Warn(fmt.Sprintf("! %s loses its redacted webhook_secret, set it again after the import", name))
+	}
+	if *dryRun {
+		return 0
+	}
+
+	if err := plan.Apply(s); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	c.Ui.Output("Imported.")
+	return 0
+}
+
+// commandConfig reads the configuration of the environment for a command other than the agent,
+// with the keyspace overridden if any.
+func commandConfig(env string, keyspace string) (*Configuration, error) {
+	if !StringInSlice(env, []string{"local", "dev", "sit", "prod"}) {
+		return nil, fmt.Errorf("Wrong argument, environment should be in (local, dev, sit, prod)")
+	}
+	Options.Env = env
+
+	config := ReadConfig()
+	if keyspace != "" {
+		config.Keyspace = keyspace
+	}
+	InitLogger("error", "stdout")
+	return config, nil
+}
@@ -0,0 +1,148 @@
+package khronos
+
+import (
+	"strings"
+	"testing"
+)
+
+//go test -v -run=TestArchiveImport
+func TestArchiveImport(t *testing.T) {
+	dev := NewMemoryStore("/khronos-dev")
+	dev.SetCalendar(&Calendar{Name: "holidays", Dates: []string{"2018-10-01"}})
+	dev.SetTemplate(&Template{Name: "spider", Job: Job{Name: "spider-${coin}", Schedule: "@every 5s", JobType: "rpc", Application: "spider"}})
+	dev.SetJob(&Job{Name: "spider-eth", Schedule: "@every 5s", JobType: "rpc", Application: "spider", Calendars: []string{"holidays"}})
+	dev.SetJob(&Job{Name: "parser", Schedule: "@every 1m", JobType: "rpc", Application: "parser", Payload: map[string]string{"retries": "3"}})
+	dev.SetProcessor(&Processor{Application: "spider", NodeName: "server-001", IP: "127.0.0.1", Port: 9009})
+
+	archive, err := Export(dev, "/khronos-dev", false, false)
+	if err != nil {
+		t.Fatalf("error exporting: %s", err)
+	}
+	if archive.Processors != nil {
+		t.Fatalf("expected the processors left out")
+	}
+
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		data, err := archive.Encode(format)
+		if err != nil {
+			t.Fatalf("error encoding %s: %s", format, err)
+		}
+		decoded, err := DecodeArchive(data, format)
+		if err != nil {
+			t.Fatalf("error decoding %s: %s", format, err)
+		}
+		if len(decoded.Jobs) != 2 || len(decoded.Templates) != 1 || len(decoded.Calendars) != 1 || decoded.Processors != nil {
+			t.Fatalf("unexpected %s archive %+v", format, decoded)
+		}
+		if decoded.Jobs[0].Name != "parser" || decoded.Jobs[0].Payload["retries"] != "3" {
+			t.Fatalf("unexpected job of the %s archive %+v", format, decoded.Jobs[0])
+		}
+	}
+
+	// a job of prod only
+	prod := NewMemoryStore("/khronos-prod")
+	prod.SetJob(&Job{Name: "legacy", Schedule: "@every 1h", JobType: "rpc", Application: "legacy"})
+	prod.SetJob(&Job{Name: "parser", Schedule: "@every 5m", JobType: "rpc", Application: "parser", Payload: map[string]string{"retries": "3"}})
+
+	plan, err := archive.Plan(prod, ImportMerge)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "3 to create, 1 to update, 0 to delete, 0 unchanged" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+	if plan.Changes[0].Kind != "calendar" {
+		t.Fatalf("expected the calendar created first got %s", plan.Changes[0])
+	}
+	for _, c := range plan.Changes {
+		if c.Action == ActionUpdate && c.String() != "~ job parser (schedule)" {
+			t.Fatalf("unexpected update %s", c)
+		}
+	}
+
+	// nothing is changed until the plan is applied
+	if jobs, _ := prod.GetJobs(); len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs before the import got %d", len(jobs))
+	}
+	if err := plan.Apply(prod); err != nil {
+		t.Fatalf("error importing: %s", err)
+	}
+
+	plan, err = archive.Plan(prod, ImportReplace)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "0 to create, 0 to update, 1 to delete, 4 unchanged" || plan.Changes[0].String() != "- job legacy" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+	if err := plan.Apply(prod); err != nil {
+		t.Fatalf("error importing: %s", err)
+	}
+	if _, err := prod.GetJob("legacy"); err == nil {
+		t.Fatalf("expected the job legacy deleted")
+	}
+}
+
+//go test -v -run=TestArchiveInvalid
+func TestArchiveInvalid(t *testing.T) {
+	if _, err := DecodeArchive([]byte(`{"version": 2}`), FormatJSON); err == nil {
+		t.Fatalf("expected a later version refused")
+	}
+
+	archive := &Archive{
+		Version: ArchiveVersion,
+		Jobs: []*Job{
+			{Name: "spider", Schedule: "@every 5s", JobType: "rpc", Application: "spider"},
+			{Name: "spider", Schedule: "@every 5s", JobType: "rpc", Application: "spider"},
+		},
+	}
+	if _, err := archive.Plan(createTestStore(), ImportMerge); err == nil {
+		t.Fatalf("expected the job defined twice refused")
+	}
+	if _, err := archive.Plan(createTestStore(), "overwrite"); err == nil {
+		t.Fatalf("expected an unknown strategy refused")
+	}
+}
+
+//go test -v -run=TestArchiveSecrets
+func TestArchiveSecrets(t *testing.T) {
+	dev := NewMemoryStore("/khronos-dev")
+	dev.SetJob(&Job{Name: "build", Schedule: "@every 1h", JobType: "rpc", Application: "ci", WebhookSecret: "dev-secret"})
+	dev.SetJob(&Job{Name: "deploy", Schedule: "@every 1h", JobType: "rpc", Application: "ci", WebhookSecret: "dev-secret"})
+
+	archive, err := Export(dev, "/khronos-dev", false, false)
+	if err != nil {
+		t.Fatalf("error exporting: %s", err)
+	}
+	data, _ := archive.Encode(FormatJSON)
+	if strings.Contains(string(data), "dev-secret") {
+		t.Fatalf("expected the secrets redacted got %s", data)
+	}
+	if withSecrets, _ := Export(dev, "/khronos-dev", false, true); withSecrets.Jobs[0].WebhookSecret != "dev-secret" {
+		t.Fatalf("expected the secrets exported with secrets")
+	}
+
+	// the redacted secret of an existing job is kept, a new job gets none
+	prod := NewMemoryStore("/khronos-prod")
+	prod.SetJob(&Job{Name: "build", Schedule: "@every 1h", JobType: "rpc", Application: "ci", WebhookSecret: "prod-secret"})
+	decoded, _ := DecodeArchive(data, FormatJSON)
+	plan, err := decoded.Plan(prod, ImportMerge)
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	if plan.Summary() != "1 to create, 0 to update, 0 to delete, 1 unchanged" {
+		t.Fatalf("unexpected plan %s", plan.Summary())
+	}
+	if len(plan.LostSecrets) != 1 || plan.LostSecrets[0] != "job deploy" {
+		t.Fatalf("expected the secret of deploy lost got %v", plan.LostSecrets)
+	}
+	if err := plan.Apply(prod); err != nil {
+		t.Fatalf("error importing: %s", err)
+	}
+	if j, _ := prod.GetJob("build"); j.WebhookSecret != "prod-secret" {
+		t.Fatalf("expected the secret of prod kept got %s", j.WebhookSecret)
+	}
+	if j, _ := prod.GetJob("deploy"); j.WebhookSecret != "" {
+		t.Fatalf("expected no secret for a new job got %s", j.WebhookSecret)
+	}
+}
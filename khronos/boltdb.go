@@ -0,0 +1,155 @@
+package khronos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+	"github.com/abronan/valkeyrie/store/boltdb"
+)
+
+const (
+	// BoltBucket is the bucket of the keyspace in a BoltDB file.
+	BoltBucket = "khronos"
+
+	// BoltPollInterval is how often the watched keys of a BoltDB file are listed.
+	BoltPollInterval = time.Second
+
+	// BoltTimeout is how long opening a BoltDB file waits for the lock held by another process, e.g. a running agent.
+	BoltTimeout = 5 * time.Second
+)
+
+// newBoltKV opens the BoltDB file at the path of the backend machines, registered as the boltdb backend.
+// BoltDB doesn't watch the keys, so they're listed every BoltPollInterval instead.
+// The file is locked by the process which opened it, the commands can't open it while an agent is running.
+func newBoltKV(addrs []string, options *store.Config) (store.Store, error) {
+	if options == nil {
+		options = &store.Config{}
+	}
+	if options.Bucket == "" {
+		options.Bucket = BoltBucket
+	}
+	// the file is opened once for the agent
+	options.PersistConnection = true
+	if options.ConnectionTimeout == 0 {
+		options.ConnectionTimeout = BoltTimeout
+	}
+
+	kv, err := boltdb.New(addrs, options)
+	if err != nil {
+		return nil, fmt.Errorf("boltdb: can't open %s within %s, is an agent running on it? %s",
+			strings.Join(addrs, ","), options.ConnectionTimeout, err)
+	}
+	return &pollingKV{Store: kv, interval: BoltPollInterval}, nil
+}
+
+// pollingKV adds the watches to a backend without them by polling the keys.
+// It lists the directories rather than the prefixes as the other backends do.
+type pollingKV struct {
+	store.Store
+	interval time.Duration
+}
+
+// List lists the keys under the directory, not the keys starting with its name.
+func (p *pollingKV) List(directory string, options *store.ReadOptions) ([]*store.KVPair, error) {
+	return p.Store.List(strings.TrimSuffix(directory, "/")+"/", options)
+}
+
+// DeleteTree deletes the directory and the keys under it, not the keys starting with its name.
+func (p *pollingKV) DeleteTree(directory string) error {
+	directory = strings.TrimSuffix(directory, "/")
+	if err := p.Store.DeleteTree(directory + "/"); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	if err := p.Store.Delete(directory); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// Watch sends the pair of the key as it is, then every time it's found changed,
+// a deleted key is sent without value.
+func (p *pollingKV) Watch(key string, stopCh <-chan struct{}, options *store.ReadOptions) (<-chan *store.KVPair, error) {
+	pair, err := p.Get(key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		last := uint64(0)
+		for {
+			if sum := fingerprint([]*store.KVPair{pair}); sum != last {
+				select {
+				case out <- pair:
+				case <-stopCh:
+					return
+				}
+				last = sum
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(p.interval):
+			}
+
+			current, err := p.Get(key, options)
+			switch {
+			case err == store.ErrKeyNotFound:
+				pair = &store.KVPair{Key: key}
+			case err == nil:
+				pair = current
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree sends the pairs under the directory as they are, then every time they're found changed.
+func (p *pollingKV) WatchTree(directory string, stopCh <-chan struct{}, options *store.ReadOptions) (<-chan []*store.KVPair, error) {
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		first := true
+		last := uint64(0)
+		for {
+			pairs, err := p.List(directory, options)
+			if err == store.ErrKeyNotFound {
+				pairs, err = []*store.KVPair{}, nil
+			}
+			// a failed listing is retried at the next poll
+			if sum := fingerprint(pairs); err == nil && (first || sum != last) {
+				select {
+				case out <- pairs:
+				case <-stopCh:
+					return
+				}
+				first = false
+				last = sum
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(p.interval):
+			}
+		}
+	}()
+	return out, nil
+}
+
+// fingerprint hashes the keys and the values of the pairs to tell whether they've changed.
+func fingerprint(pairs []*store.KVPair) uint64 {
+	h := fnv.New64a()
+	for _, pair := range pairs {
+		h.Write([]byte(pair.Key))
+		h.Write([]byte{0})
+		h.Write(pair.Value)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
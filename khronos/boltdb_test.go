@@ -0,0 +1,144 @@
+package khronos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+)
+
+//go test -v -run=TestPollingWatchTree
+func TestPollingWatchTree(t *testing.T) {
+	kv, _ := NewMemoryKV(nil, nil)
+	p := &pollingKV{Store: kv, interval: 10 * time.Millisecond}
+	p.Put("/khronos/jobs/spider", []byte("v1"), nil)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := p.WatchTree("/khronos/jobs", stopCh, nil)
+	if err != nil {
+		t.Fatalf("error watching: %s", err)
+	}
+
+	next := func() []*store.KVPair {
+		select {
+		case pairs := <-events:
+			return pairs
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event")
+		}
+		return nil
+	}
+
+	if pairs := next(); len(pairs) != 1 {
+		t.Fatalf("expected the current job got %v", pairs)
+	}
+
+	// nothing is sent while nothing changes
+	select {
+	case pairs := <-events:
+		t.Fatalf("unexpected event %v", pairs)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.Put("/khronos/jobs/spider", []byte("v2"), nil)
+	if pairs := next(); len(pairs) != 1 || string(pairs[0].Value) != "v2" {
+		t.Fatalf("expected the updated job got %v", pairs)
+	}
+
+	p.Delete("/khronos/jobs/spider")
+	if pairs := next(); len(pairs) != 0 {
+		t.Fatalf("expected no job got %v", pairs)
+	}
+}
+
+//go test -v -run=TestPollingWatch
+func TestPollingWatch(t *testing.T) {
+	kv, _ := NewMemoryKV(nil, nil)
+	p := &pollingKV{Store: kv, interval: 10 * time.Millisecond}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if _, err := p.Watch("/khronos/jobs/spider", stopCh, nil); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound got %v", err)
+	}
+
+	p.Put("/khronos/jobs/spider", []byte("v1"), nil)
+	events, err := p.Watch("/khronos/jobs/spider", stopCh, nil)
+	if err != nil {
+		t.Fatalf("error watching: %s", err)
+	}
+	if pair := <-events; string(pair.Value) != "v1" {
+		t.Fatalf("expected v1 got %v", pair)
+	}
+
+	p.Delete("/khronos/jobs/spider")
+	select {
+	case pair := <-events:
+		if pair.Value != nil {
+			t.Fatalf("expected the deleted key got %v", pair)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected an event")
+	}
+}
+
+//go test -v -run=TestBoltKV
+func TestBoltKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khronos-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "khronos.db")
+	kv, err := newBoltKV([]string{path}, nil)
+	if err != nil {
+		t.Fatalf("error opening %s: %s", path, err)
+	}
+	defer kv.Close()
+
+	// a put without previous pair creates the key only
+	ok, pair, err := kv.AtomicPut("khronos/jobs/spider", []byte("v1"), nil, nil)
+	if err != nil || !ok {
+		t.Fatalf("error creating the key: %v", err)
+	}
+	if ok, _, err := kv.AtomicPut("khronos/jobs/spider", []byte("v1"), nil, nil); ok || err == nil {
+		t.Fatalf("expected an existing key not created again")
+	}
+	if ok, _, err := kv.AtomicPut("khronos/jobs/spider", []byte("v2"), pair, nil); err != nil || !ok {
+		t.Fatalf("error updating the key: %v", err)
+	}
+	// the previous pair is stale now
+	if ok, _, err := kv.AtomicPut("khronos/jobs/spider", []byte("v3"), pair, nil); ok || err == nil {
+		t.Fatalf("expected a stale pair refused")
+	}
+	if pair, err := kv.Get("khronos/jobs/spider", nil); err != nil || string(pair.Value) != "v2" {
+		t.Fatalf("expected v2 got %v, %v", pair, err)
+	}
+
+	// the directories are listed and deleted, not the keys starting with their name
+	kv.Put("khronos/jobs/crawler", []byte("v1"), nil)
+	kv.Put("khronos/jobs-archive/spider", []byte("v1"), nil)
+	pairs, err := kv.List("khronos/jobs", nil)
+	if err != nil || len(pairs) != 2 {
+		t.Fatalf("expected 2 jobs got %v, %v", pairs, err)
+	}
+	if err := kv.DeleteTree("khronos/jobs"); err != nil {
+		t.Fatalf("error deleting the jobs: %s", err)
+	}
+	if _, err := kv.List("khronos/jobs", nil); err != store.ErrKeyNotFound {
+		t.Fatalf("expected the jobs deleted got %v", err)
+	}
+	if pairs, err := kv.List("khronos/jobs-archive", nil); err != nil || len(pairs) != 1 {
+		t.Fatalf("expected the archive kept got %v, %v", pairs, err)
+	}
+
+	// the file is locked while it's open
+	if _, err := newBoltKV([]string{path}, &store.Config{ConnectionTimeout: 100 * time.Millisecond}); err == nil {
+		t.Fatalf("expected an error opening a locked file")
+	}
+}
@@ -0,0 +1,244 @@
+package khronos
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Calendar suppresses the schedules of jobs during blackouts,
+// e.g. the maintenances of an exchange and the holidays.
+type Calendar struct {
+	//the calendar name must be unique in all of calendars
+	Name string `json:"name"`
+
+	// a breif description for calendar
+	Breif string `json:"breif"`
+
+	// Whole days of blackout, e.g. ["2018-10-01", "2018-10-02"]
+	Dates []string `json:"dates"`
+
+	// Recurring blackout windows
+	Windows []CalendarWindow `json:"windows"`
+
+	// Blackout events, e.g. imported from an iCalendar (.ics) file
+	Events []CalendarEvent `json:"events"`
+
+	// The calendar applies to all of the jobs of these applications,
+	// besides the jobs referencing it by name.
+	Applications []string `json:"applications"`
+
+	// Time zone of the dates and windows, e.g. "Asia/Hong_Kong". Default to local.
+	Location string `json:"location"`
+}
+
+// CalendarWindow is a blackout starting at every activation of a schedule.
+type CalendarWindow struct {
+	// e.g. "0 0 2 * * SAT" for every saturday at 2am
+	Schedule string `json:"schedule"`
+
+	// e.g. "4h"
+	Duration string `json:"duration"`
+}
+
+// CalendarEvent is a blackout from Start until End.
+type CalendarEvent struct {
+	Summary string `json:"summary"`
+
+	Start time.Time `json:"start"`
+
+	End time.Time `json:"end"`
+}
+
+// Validate checks the dates, windows and location of the calendar.
+func (c *Calendar) Validate() error {
+	if c.Name == "" || strings.Contains(c.Name, "/") {
+		return fmt.Errorf("calendar: invalid name '%s'", c.Name)
+	}
+
+	if _, err := c.location(); err != nil {
+		return fmt.Errorf("calendar: invalid location '%s': %s", c.Location, err)
+	}
+
+	for _, d := range c.Dates {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("calendar: invalid date '%s', expected YYYY-MM-DD", d)
+		}
+	}
+
+	for _, w := range c.Windows {
+		if _, err := ParseSchedule(w.Schedule); err != nil {
+			return fmt.Errorf("calendar: invalid window schedule '%s': %s", w.Schedule, err)
+		}
+		if d, err := time.ParseDuration(w.Duration); err != nil || d <= 0 {
+			return fmt.Errorf("calendar: invalid window duration '%s'", w.Duration)
+		}
+	}
+
+	for _, e := range c.Events {
+		if e.End.Before(e.Start) {
+			return fmt.Errorf("calendar: event '%s' ends before it starts", e.Summary)
+		}
+	}
+
+	return nil
+}
+
+// AppliesTo reports whether the calendar suppresses the schedules of the job.
+func (c *Calendar) AppliesTo(j *Job) bool {
+	return StringInSlice(c.Name, j.Calendars) || StringInSlice(j.Application, c.Applications)
+}
+
+// Contains reports whether the time is in a blackout of the calendar.
+func (c *Calendar) Contains(t time.Time) bool {
+	loc, err := c.location()
+	if err != nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+
+	if StringInSlice(t.Format("2006-01-02"), c.Dates) {
+		return true
+	}
+
+	for _, w := range c.Windows {
+		sched, err := ParseSchedule(w.Schedule)
+		if err != nil {
+			continue
+		}
+		d, err := time.ParseDuration(w.Duration)
+		if err != nil {
+			continue
+		}
+		// the first window starting after t-d covers t if it has started by t
+		start := sched.Next(t.Add(-d))
+		if !start.IsZero() && !start.After(t) {
+			return true
+		}
+	}
+
+	for _, e := range c.Events {
+		if !t.Before(e.Start) && t.Before(e.End) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Calendar) location() (*time.Location, error) {
+	if c.Location == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Location)
+}
+
+// ParseICS reads the events of an iCalendar (.ics) file.
+// The recurring events aren't supported, they should be written as windows.
+func ParseICS(data []byte) ([]CalendarEvent, error) {
+	// unfold the lines continued by a leading space or tab
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	events := make([]CalendarEvent, 0)
+	var event *CalendarEvent
+	var allDay bool
+	for _, line := range lines {
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		name, value := line[:idx], line[idx+1:]
+		params := strings.Split(name, ";")
+		name = strings.ToUpper(params[0])
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &CalendarEvent{}
+			allDay = false
+
+		case name == "END" && value == "VEVENT":
+			if event == nil {
+				return nil, fmt.Errorf("ics: END:VEVENT without BEGIN:VEVENT")
+			}
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("ics: event '%s' without DTSTART", event.Summary)
+			}
+			if event.End.IsZero() {
+				if allDay {
+					event.End = event.Start.AddDate(0, 0, 1)
+				} else {
+					event.End = event.Start
+				}
+			}
+			events = append(events, *event)
+			event = nil
+
+		case event == nil:
+			continue
+
+		case name == "SUMMARY":
+			event.Summary = value
+
+		case name == "RRULE":
+			return nil, fmt.Errorf("ics: recurring event '%s' isn't supported, use a window instead", event.Summary)
+
+		case name == "DTSTART" || name == "DTEND":
+			t, date, err := parseICSTime(value, params[1:])
+			if err != nil {
+				return nil, err
+			}
+			if name == "DTSTART" {
+				event.Start = t
+				allDay = date
+			} else {
+				event.End = t
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, it reports whether it's a DATE.
+func parseICSTime(value string, params []string) (time.Time, bool, error) {
+	loc := time.Local
+	for _, p := range params {
+		if strings.HasPrefix(strings.ToUpper(p), "TZID=") {
+			l, err := time.LoadLocation(p[len("TZID="):])
+			if err != nil {
+				return time.Time{}, false, fmt.Errorf("ics: unknown time zone '%s'", p)
+			}
+			loc = l
+		}
+	}
+
+	if len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("ics: invalid time '%s'", value)
+	}
+	return t, false, nil
+}
@@ -0,0 +1,95 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestCalendarContains
+func TestCalendarContains(t *testing.T) {
+	c := &Calendar{
+		Name:     "exchange",
+		Dates:    []string{"2018-10-01"},
+		Windows:  []CalendarWindow{{Schedule: "0 0 2 * * SAT", Duration: "4h"}},
+		Location: "UTC",
+		Events: []CalendarEvent{{
+			Summary: "upgrade",
+			Start:   time.Date(2018, 6, 12, 8, 0, 0, 0, time.UTC),
+			End:     time.Date(2018, 6, 12, 9, 0, 0, 0, time.UTC),
+		}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected valid calendar got: %s", err)
+	}
+
+	cases := map[time.Time]bool{
+		time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC):   true,
+		time.Date(2018, 10, 1, 23, 59, 0, 0, time.UTC): true,
+		time.Date(2018, 10, 2, 0, 0, 0, 0, time.UTC):   false,
+		// saturday 2018-06-09
+		time.Date(2018, 6, 9, 1, 59, 59, 0, time.UTC): false,
+		time.Date(2018, 6, 9, 2, 0, 0, 0, time.UTC):   true,
+		time.Date(2018, 6, 9, 5, 59, 59, 0, time.UTC): true,
+		time.Date(2018, 6, 9, 6, 0, 0, 0, time.UTC):   false,
+		time.Date(2018, 6, 12, 8, 30, 0, 0, time.UTC): true,
+		time.Date(2018, 6, 12, 9, 0, 0, 0, time.UTC):  false,
+	}
+	for at, expected := range cases {
+		if got := c.Contains(at); got != expected {
+			t.Fatalf("expected %t at %s got %t", expected, at, got)
+		}
+	}
+}
+
+//go test -v -run=TestCalendarAppliesTo
+func TestCalendarAppliesTo(t *testing.T) {
+	c := &Calendar{Name: "holidays", Applications: []string{"spider"}}
+
+	if !c.AppliesTo(&Job{Application: "spider"}) {
+		t.Fatal("expected calendar to apply to the jobs of its application")
+	}
+	if !c.AppliesTo(&Job{Application: "report", Calendars: []string{"holidays"}}) {
+		t.Fatal("expected calendar to apply to the jobs referencing it")
+	}
+	if c.AppliesTo(&Job{Application: "report"}) {
+		t.Fatal("expected calendar not to apply to other jobs")
+	}
+}
+
+//go test -v -run=TestParseICS
+func TestParseICS(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:National\r\n  Day\r\n" +
+		"DTSTART;VALUE=DATE:20181001\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Maintenance\r\n" +
+		"DTSTART:20180612T080000Z\r\n" +
+		"DTEND:20180612T090000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := ParseICS([]byte(ics))
+	if err != nil {
+		t.Fatalf("error parsing ics: %s", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events got: %d", len(events))
+	}
+	if events[0].Summary != "National Day" {
+		t.Fatalf("expected unfolded summary got: %s", events[0].Summary)
+	}
+	if events[0].End.Sub(events[0].Start) != 24*time.Hour {
+		t.Fatalf("expected an all day event got: %s - %s", events[0].Start, events[0].End)
+	}
+	if !events[1].Start.Equal(time.Date(2018, 6, 12, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected start of event: %s", events[1].Start)
+	}
+
+	recurring := "BEGIN:VEVENT\r\nDTSTART:20180612T080000Z\r\nRRULE:FREQ=YEARLY\r\nEND:VEVENT\r\n"
+	if _, err := ParseICS([]byte(recurring)); err == nil {
+		t.Fatal("expected error parsing a recurring event")
+	}
+}
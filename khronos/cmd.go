@@ -36,10 +36,10 @@ Options:
   -bind-ip=0.0.0.0		          Address to bind network listeners to.
   -bind-port=10001        		  Address to bind network listeners to.
   -node=hostname                  Name of this node. Must be unique in the cluster
-  -backend=[etcd|consul|zk|redis] Backend storage to use, etcd, consul, zk (zookeeper) or redis.
-                                  The default is etcd.
-  -backend-machine=127.0.0.1:2379 Backend storage servers addresses to connect to. This flag can be
-                                  specified multiple times.
+  -backend=[etcdv3|boltdb|memory] Backend storage to use, etcdv3, boltdb (a single file) or memory
+                                  (a single agent, lost on restart). The default is etcdv3.
+  -backend-machine=127.0.0.1:2379 Backend storage servers addresses to connect to, or the path of the
+                                  file of boltdb. This flag can be specified multiple times.
   -rpc-port=10005                 RPC Port used to communicate with clients. Only used when server.
                                   The RPC IP Address will be the same as the bind address.
   -mail-host                      Mail server host address to use for notifications.
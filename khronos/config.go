@@ -3,6 +3,7 @@ package khronos
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-ini/ini"
 	log "github.com/sirupsen/logrus"
@@ -19,11 +20,22 @@ type Configuration struct {
 	BindIP   string
 	BindPort int
 	RPCPort  int
-	//storage e.g. etcd,etcdv3
+	//storage e.g. etcdv3,boltdb,memory
 	Backend         string
 	BackendMachines []string
 	Keyspace        string
 
+	//default retention of the executions of jobs, and how often they're pruned
+	Retention       Retention
+	JanitorInterval time.Duration
+
+	//the job files applied by the agent every interval, see PlanApply.
+	//the agents don't coordinate the applies, the dir is set on a single agent.
+	ApplyDir      string
+	ApplySource   string
+	ApplyPrune    bool
+	ApplyInterval time.Duration
+
 	MailHost          string
 	MailPort          int
 	MailUsername      string
@@ -81,6 +93,16 @@ func ReadConfig() *Configuration {
 		Backend:         cfg.Section("").Key("backend").String(),
 		BackendMachines: cfg.Section("").Key("backend-machines").Strings(","),
 		Keyspace:        cfg.Section("").Key("keyspace").String(),
+		Retention: NewRetention(
+			cfg.Section("").Key("retention-max-executions").MustInt(MaxExecutions),
+			cfg.Section("").Key("retention-max-age").String(),
+			cfg.Section("").Key("retention-keep-failures").MustInt(),
+		),
+		JanitorInterval: cfg.Section("").Key("janitor-interval").MustDuration(DefaultJanitorInterval),
+		ApplyDir:        cfg.Section("").Key("apply-dir").String(),
+		ApplySource:     cfg.Section("").Key("apply-source").MustString(DefaultApplySource),
+		ApplyPrune:      cfg.Section("").Key("apply-prune").MustBool(),
+		ApplyInterval:   cfg.Section("").Key("apply-interval").MustDuration(DefaultApplyInterval),
 
 		MailHost:          cfg.Section("").Key("mail-host").String(),
 		MailPort:          cfg.Section("").Key("mail-port").MustInt(),
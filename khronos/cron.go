@@ -0,0 +1,474 @@
+package khronos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// SpecSchedule is a cron schedule with an optional seconds field.
+// On top of the usual * / , - ? it supports in the day of month field
+// L (last day), L-n (n days before the last day), nW (nearest weekday of day n)
+// and LW (last weekday), and in the day of week field
+// nL (last weekday n of the month) and n#k (k-th weekday n of the month).
+type SpecSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// the day of month or day of week field is * or ?
+	domStar, dowStar bool
+
+	// L and L-n, as offsets from the last day of the month
+	domLast []int
+
+	// nW, the days whose nearest weekday matches
+	domNearest []int
+
+	// LW
+	domLastWeekday bool
+
+	// nL, the weekdays whose last occurence in the month matches
+	dowLast []time.Weekday
+
+	// n#k
+	dowNth []nthWeekday
+}
+
+type nthWeekday struct {
+	weekday time.Weekday
+	nth     int
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{"second", 0, 59, nil}
+	minuteField = cronField{"minute", 0, 59, nil}
+	hourField   = cronField{"hour", 0, 23, nil}
+	domField    = cronField{"day of month", 1, 31, nil}
+	monthField  = cronField{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is sunday as well
+	dowField = cronField{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// ParseCron parses a cron spec of 5 fields (minute hour dom month dow) like a crontab line,
+// or of 6 fields with the seconds first, or a predefined schedule, or an @every interval.
+func ParseCron(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("cron: empty spec")
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid @every duration in '%s': %s", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("cron: @every duration in '%s' should be at least 1s", spec)
+		}
+		return cron.Every(d), nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		fields, ok := cronDescriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown predefined schedule '%s'", spec)
+		}
+		spec = fields
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields in '%s', got %d", spec, len(fields))
+	}
+
+	s := &SpecSchedule{}
+	var err error
+	if s.second, err = parseCronField(fields[0], secondField); err != nil {
+		return nil, err
+	}
+	if s.minute, err = parseCronField(fields[1], minuteField); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[2], hourField); err != nil {
+		return nil, err
+	}
+	if err = s.parseDom(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[4], monthField); err != nil {
+		return nil, err
+	}
+	if err = s.parseDow(fields[5]); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// parseDom parses the day of month field with its L and W expressions.
+func (s *SpecSchedule) parseDom(field string) error {
+	if field == "*" || field == "?" {
+		s.domStar = true
+		s.dom = cronBits(domField.min, domField.max, 1)
+		return nil
+	}
+
+	for _, expr := range strings.Split(field, ",") {
+		upper := strings.ToUpper(expr)
+		switch {
+		case upper == "LW":
+			s.domLastWeekday = true
+
+		case upper == "L":
+			s.domLast = append(s.domLast, 0)
+
+		case strings.HasPrefix(upper, "L-"):
+			n, err := strconv.Atoi(upper[2:])
+			if err != nil || n < 1 || n > 30 {
+				return fmt.Errorf("cron: invalid %s expression '%s', expected L-n with n between 1 and 30", domField.name, expr)
+			}
+			s.domLast = append(s.domLast, n)
+
+		case strings.HasSuffix(upper, "W"):
+			n, err := strconv.Atoi(upper[:len(upper)-1])
+			if err != nil || n < domField.min || n > domField.max {
+				return fmt.Errorf("cron: invalid %s expression '%s', expected nW with n between 1 and 31", domField.name, expr)
+			}
+			s.domNearest = append(s.domNearest, n)
+
+		default:
+			bits, err := parseCronExpr(expr, domField)
+			if err != nil {
+				return err
+			}
+			s.dom |= bits
+		}
+	}
+
+	return nil
+}
+
+// parseDow parses the day of week field with its L and # expressions.
+func (s *SpecSchedule) parseDow(field string) error {
+	if field == "*" || field == "?" {
+		s.dowStar = true
+		s.dow = cronBits(0, 6, 1)
+		return nil
+	}
+
+	for _, expr := range strings.Split(field, ",") {
+		upper := strings.ToUpper(expr)
+		switch {
+		case strings.Contains(upper, "#"):
+			parts := strings.SplitN(upper, "#", 2)
+			wd, err := parseCronValue(parts[0], dowField)
+			if err != nil {
+				return err
+			}
+			nth, err := strconv.Atoi(parts[1])
+			if err != nil || nth < 1 || nth > 5 {
+				return fmt.Errorf("cron: invalid %s expression '%s', expected n#k with k between 1 and 5", dowField.name, expr)
+			}
+			s.dowNth = append(s.dowNth, nthWeekday{time.Weekday(wd % 7), nth})
+
+		case len(upper) > 1 && strings.HasSuffix(upper, "L"):
+			wd, err := parseCronValue(upper[:len(upper)-1], dowField)
+			if err != nil {
+				return err
+			}
+			s.dowLast = append(s.dowLast, time.Weekday(wd%7))
+
+		default:
+			bits, err := parseCronExpr(expr, dowField)
+			if err != nil {
+				return err
+			}
+			// 7 is sunday
+			if bits&(1<<7) != 0 {
+				bits = bits&^(1<<7) | 1
+			}
+			s.dow |= bits
+		}
+	}
+
+	return nil
+}
+
+// parseCronField parses a comma separated list of expressions of a field.
+func parseCronField(field string, f cronField) (uint64, error) {
+	if field == "?" {
+		return 0, fmt.Errorf("cron: '?' is only allowed in the day of month and day of week fields")
+	}
+
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		b, err := parseCronExpr(expr, f)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parseCronExpr parses *, a, a-b, */n, a/n or a-b/n.
+func parseCronExpr(expr string, f cronField) (uint64, error) {
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, fmt.Errorf("cron: invalid %s expression '%s'", f.name, expr)
+	}
+
+	start, end := f.min, f.max
+	if f.name == dowField.name {
+		// * in the day of week field doesn't include 7
+		end = 6
+	}
+
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+	if len(lowAndHigh) > 2 {
+		return 0, fmt.Errorf("cron: invalid %s expression '%s'", f.name, expr)
+	}
+
+	if lowAndHigh[0] != "*" {
+		var err error
+		if start, err = parseCronValue(lowAndHigh[0], f); err != nil {
+			return 0, err
+		}
+		end = start
+		if len(lowAndHigh) == 2 {
+			if end, err = parseCronValue(lowAndHigh[1], f); err != nil {
+				return 0, err
+			}
+		} else if len(rangeAndStep) == 2 {
+			// a/n means from a to the max
+			end = f.max
+		}
+	} else if len(lowAndHigh) == 2 {
+		return 0, fmt.Errorf("cron: invalid %s expression '%s'", f.name, expr)
+	}
+
+	step := 1
+	if len(rangeAndStep) == 2 {
+		var err error
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step < 1 {
+			return 0, fmt.Errorf("cron: invalid step in %s expression '%s'", f.name, expr)
+		}
+	}
+
+	if start > end {
+		return 0, fmt.Errorf("cron: invalid %s range '%s', %d is beyond %d", f.name, expr, start, end)
+	}
+
+	return cronBits(start, end, step), nil
+}
+
+// parseCronValue parses a number or a name within the bounds of a field.
+func parseCronValue(value string, f cronField) (int, error) {
+	if n, ok := f.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid %s value '%s'", f.name, value)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("cron: %s value %d is out of range %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+func cronBits(min, max, step int) uint64 {
+	var bits uint64
+	for i := min; i <= max; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits
+}
+
+// Next returns the next time matching the schedule after the given time,
+// or the zero time if none matches within five years.
+func (s *SpecSchedule) Next(t time.Time) time.Time {
+	// start from the next whole second
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+
+	// whether a field has been incremented, then the lower fields restart from zero
+	added := false
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+// dayMatches reports whether the day matches, when both the day of month and the day of week
+// are restricted either of them matching is enough, as in crontab.
+func (s *SpecSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.domMatches(t)
+	dowMatch := s.dowMatches(t)
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *SpecSchedule) domMatches(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&s.dom != 0 {
+		return true
+	}
+
+	last := lastDayOfMonth(t)
+	for _, offset := range s.domLast {
+		if day == last-offset {
+			return true
+		}
+	}
+
+	for _, n := range s.domNearest {
+		if day == nearestWeekday(t, n) {
+			return true
+		}
+	}
+
+	if s.domLastWeekday && day == nearestWeekday(t, last) {
+		return true
+	}
+
+	return false
+}
+
+func (s *SpecSchedule) dowMatches(t time.Time) bool {
+	wd := t.Weekday()
+	if 1<<uint(wd)&s.dow != 0 {
+		return true
+	}
+
+	for _, l := range s.dowLast {
+		if wd == l && t.Day()+7 > lastDayOfMonth(t) {
+			return true
+		}
+	}
+
+	for _, n := range s.dowNth {
+		if wd == n.weekday && (t.Day()-1)/7+1 == n.nth {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastDayOfMonth returns the last day of the month of the time.
+func lastDayOfMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// nearestWeekday returns the weekday nearest to the day n of the month of the time,
+// without leaving the month.
+func nearestWeekday(t time.Time, n int) int {
+	last := lastDayOfMonth(t)
+	if n > last {
+		n = last
+	}
+
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return n + 2
+		}
+		return n - 1
+	case time.Sunday:
+		if n == last {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
+}
@@ -34,3 +34,136 @@ func TestCronAddJob(t *testing.T) {
 		return
 	}
 }
+
+//go test -v -run=TestParseCronNext
+func TestParseCronNext(t *testing.T) {
+	cases := []struct {
+		spec     string
+		from     string
+		expected []string
+	}{
+		// 6 fields with seconds
+		{"*/15 * * * * *", "2018-06-08T10:00:00Z", []string{"2018-06-08T10:00:15Z", "2018-06-08T10:00:30Z"}},
+		{"0 0 * * * *", "2018-06-08T10:30:00Z", []string{"2018-06-08T11:00:00Z", "2018-06-08T12:00:00Z"}},
+		// 5 fields like crontab, no seconds
+		{"30 9 * * *", "2018-06-08T10:00:00Z", []string{"2018-06-09T09:30:00Z", "2018-06-10T09:30:00Z"}},
+		{"0 9 * * MON-FRI", "2018-06-08T10:00:00Z", []string{"2018-06-11T09:00:00Z", "2018-06-12T09:00:00Z"}},
+		{"0 0 1,15 * *", "2018-06-08T10:00:00Z", []string{"2018-06-15T00:00:00Z", "2018-07-01T00:00:00Z"}},
+		{"0 0 * * 7", "2018-06-08T10:00:00Z", []string{"2018-06-10T00:00:00Z", "2018-06-17T00:00:00Z"}},
+		{"0 12 * JAN,jul *", "2018-06-08T10:00:00Z", []string{"2018-07-01T12:00:00Z", "2018-07-02T12:00:00Z"}},
+		{"0 */6 * * *", "2018-06-08T10:00:00Z", []string{"2018-06-08T12:00:00Z", "2018-06-08T18:00:00Z"}},
+		{"5/20 * * * *", "2018-06-08T10:00:00Z", []string{"2018-06-08T10:05:00Z", "2018-06-08T10:25:00Z", "2018-06-08T10:45:00Z"}},
+		// both day fields restricted, either matches
+		{"0 0 13 * FRI", "2018-06-08T10:00:00Z", []string{"2018-06-13T00:00:00Z", "2018-06-15T00:00:00Z"}},
+		// last day of month
+		{"0 0 L * ?", "2018-01-31T10:00:00Z", []string{"2018-02-28T00:00:00Z", "2018-03-31T00:00:00Z"}},
+		{"0 0 L * *", "2020-02-01T00:00:00Z", []string{"2020-02-29T00:00:00Z"}},
+		{"0 0 L-2 * *", "2018-06-01T00:00:00Z", []string{"2018-06-28T00:00:00Z", "2018-07-29T00:00:00Z"}},
+		// nearest weekday, 2018-09-15 is a saturday and 2018-07-15 a sunday
+		{"0 0 15W * *", "2018-09-01T00:00:00Z", []string{"2018-09-14T00:00:00Z", "2018-10-15T00:00:00Z"}},
+		{"0 0 15W * *", "2018-07-01T00:00:00Z", []string{"2018-07-16T00:00:00Z"}},
+		// 2018-09-01 is a saturday, the nearest weekday doesn't leave the month
+		{"0 0 1W * *", "2018-08-31T00:00:00Z", []string{"2018-09-03T00:00:00Z"}},
+		// 2018-06-30 is a saturday and june has no 31st
+		{"0 0 31W * *", "2018-06-01T00:00:00Z", []string{"2018-06-29T00:00:00Z", "2018-07-31T00:00:00Z"}},
+		// 2018-09-30 is a sunday
+		{"0 0 LW * *", "2018-09-01T00:00:00Z", []string{"2018-09-28T00:00:00Z", "2018-10-31T00:00:00Z"}},
+		// nth weekday
+		{"0 0 ? * 5#3", "2018-06-01T00:00:00Z", []string{"2018-06-15T00:00:00Z", "2018-07-20T00:00:00Z"}},
+		{"0 0 * * MON#1", "2018-06-08T00:00:00Z", []string{"2018-07-02T00:00:00Z", "2018-08-06T00:00:00Z"}},
+		{"0 0 * * 1#5", "2018-06-01T00:00:00Z", []string{"2018-07-30T00:00:00Z", "2018-10-29T00:00:00Z"}},
+		// last weekday of month
+		{"0 0 * * 5L", "2018-06-01T00:00:00Z", []string{"2018-06-29T00:00:00Z", "2018-07-27T00:00:00Z"}},
+		{"0 0 0 ? * FRIL", "2018-06-01T00:00:00Z", []string{"2018-06-29T00:00:00Z"}},
+		// predefined schedules
+		{"@hourly", "2018-06-08T10:30:00Z", []string{"2018-06-08T11:00:00Z"}},
+		{"@daily", "2018-06-08T10:30:00Z", []string{"2018-06-09T00:00:00Z"}},
+		{"@weekly", "2018-06-08T10:30:00Z", []string{"2018-06-10T00:00:00Z"}},
+		{"@monthly", "2018-06-08T10:30:00Z", []string{"2018-07-01T00:00:00Z"}},
+		{"@yearly", "2018-06-08T10:30:00Z", []string{"2019-01-01T00:00:00Z"}},
+		{"@every 90s", "2018-06-08T10:30:00Z", []string{"2018-06-08T10:31:30Z", "2018-06-08T10:33:00Z"}},
+		// leap day
+		{"0 0 29 2 *", "2018-06-08T10:30:00Z", []string{"2020-02-29T00:00:00Z", "2024-02-29T00:00:00Z"}},
+	}
+
+	for _, c := range cases {
+		sched, err := ParseCron(c.spec)
+		if err != nil {
+			t.Fatalf("error parsing '%s': %s", c.spec, err)
+		}
+
+		next, _ := time.Parse(time.RFC3339, c.from)
+		for _, e := range c.expected {
+			expected, _ := time.Parse(time.RFC3339, e)
+			next = sched.Next(next)
+			if !next.Equal(expected) {
+				t.Fatalf("'%s' from %s: expected %s got %s", c.spec, c.from, expected, next)
+			}
+		}
+	}
+}
+
+//go test -v -run=TestParseCronNever
+func TestParseCronNever(t *testing.T) {
+	sched, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+	if next := sched.Next(time.Now()); !next.IsZero() {
+		t.Fatalf("expected no activation on february 30 got: %s", next)
+	}
+}
+
+//go test -v -run=TestParseCronErrors
+func TestParseCronErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * FOO *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"*-5 * * * *",
+		"1/2/3 * * * *",
+		"1-2-3 * * * *",
+		"1-FOO * * * *",
+		"* * * * 1-9",
+		"* * * * FOO#1",
+		"? * * * *",
+		"* * L-31 * *",
+		"* * 32W * *",
+		"* * * * 5#6",
+		"* * * * 5#0",
+		"* * * * 9L",
+		"@every 1ms",
+		"@every forever",
+		"@sometimes",
+	}
+
+	for _, spec := range invalid {
+		if _, err := ParseCron(spec); err == nil {
+			t.Fatalf("expected error parsing '%s'", spec)
+		}
+	}
+}
+
+//go test -v -run=TestParseCronLocation
+func TestParseCronLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Hong_Kong")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	sched, _ := ParseCron("0 9 * * *")
+	from := time.Date(2018, 6, 8, 10, 0, 0, 0, loc)
+	expected := time.Date(2018, 6, 9, 9, 0, 0, 0, loc)
+	if next := sched.Next(from); !next.Equal(expected) {
+		t.Fatalf("expected %s got %s", expected, next)
+	}
+}
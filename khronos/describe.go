@@ -0,0 +1,294 @@
+package khronos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+var (
+	weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+	ordinals     = []string{"", "first", "second", "third", "fourth", "fifth"}
+)
+
+// DescribeSchedule explains a schedule in plain English,
+// e.g. "30 9 * * MON-FRI" is "at 09:30:00, on Monday through Friday".
+func DescribeSchedule(spec string) (string, error) {
+	sched, err := ParseSchedule(spec)
+	if err != nil {
+		return "", err
+	}
+
+	spec = strings.TrimSpace(spec)
+	switch s := sched.(type) {
+	case *AtSchedule:
+		if s.At.IsZero() {
+			return "once, as soon as it's scheduled", nil
+		}
+		return "once at " + s.At.Format(time.RFC3339), nil
+	case cron.ConstantDelaySchedule:
+		return "every " + s.Delay.String(), nil
+	}
+
+	if fields, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = fields
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+
+	desc := describeTime(fields[0], fields[1], fields[2])
+	if days := describeDays(fields[3], fields[5]); days != "" {
+		desc += ", " + days
+	}
+	if months := describeMonths(fields[4]); months != "" {
+		desc += ", " + months
+	}
+	return desc, nil
+}
+
+// Describe explains the schedule of the job with its validity window, delays and max runs.
+func (j *Job) Describe() (string, error) {
+	if j.Schedule == "" && j.Watch != "" {
+		return "on the changes under " + j.Watch, nil
+	}
+
+	desc, err := DescribeSchedule(j.Schedule)
+	if err != nil {
+		return "", err
+	}
+
+	if j.Watch != "" {
+		desc += ", and on the changes under " + j.Watch
+	}
+
+	if !j.StartAt.IsZero() {
+		desc += ", from " + j.StartAt.Format(time.RFC3339)
+	}
+	if !j.EndAt.IsZero() {
+		desc += ", until " + j.EndAt.Format(time.RFC3339)
+	}
+	if j.Spread {
+		desc += ", spread within the interval"
+	}
+	if j.Jitter != "" {
+		desc += ", with a random delay up to " + j.Jitter
+	}
+	if j.MaxRuns > 0 {
+		desc += fmt.Sprintf(", done after %d successful executions", j.MaxRuns)
+	}
+	return desc, nil
+}
+
+// NextRuns returns at most n activations of the schedule after the given time.
+func NextRuns(sched cron.Schedule, from time.Time, n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+	for t := sched.Next(from); !t.IsZero() && len(runs) < n; t = sched.Next(t) {
+		runs = append(runs, t)
+	}
+	return runs
+}
+
+// describeTime explains the seconds, minutes and hours fields.
+func describeTime(second, minute, hour string) string {
+	if isCronNumber(second) && isCronNumber(minute) && isCronNumber(hour) {
+		s, _ := strconv.Atoi(second)
+		m, _ := strconv.Atoi(minute)
+		h, _ := strconv.Atoi(hour)
+		return fmt.Sprintf("at %02d:%02d:%02d", h, m, s)
+	}
+
+	fields := []string{second, minute, hour}
+	units := []string{"second", "minute", "hour"}
+	// the second 0 goes without saying once a minute or an hour is restricted
+	if second == "0" && (minute != "*" || hour != "*") {
+		fields, units = fields[1:], units[1:]
+	}
+
+	clauses := make([]string, 0)
+	every := false
+	for i, f := range fields {
+		switch {
+		case i == 0 && f == "*":
+			clauses = append(clauses, "every "+units[i])
+			every = true
+		case i == 0 && strings.Contains(f, "/"):
+			clauses = append(clauses, describeCronList(f, units[i], nil))
+			every = true
+		case i == 0:
+			clauses = append(clauses, "at "+describeCronList(f, units[i], nil))
+		case f == "*":
+			// the first unrestricted field after an "at" clause only
+			if !every {
+				clauses = append(clauses, "of every "+units[i])
+				every = true
+			}
+		default:
+			clauses = append(clauses, "of "+describeCronList(f, units[i], nil))
+		}
+	}
+	return strings.Join(clauses, " ")
+}
+
+// describeDays explains the day of month and day of week fields.
+func describeDays(dom, dow string) string {
+	domStar := dom == "*" || dom == "?"
+	dowStar := dow == "*" || dow == "?"
+
+	var domDesc, dowDesc string
+	if !domStar {
+		items := make([]string, 0)
+		for _, expr := range strings.Split(dom, ",") {
+			upper := strings.ToUpper(expr)
+			switch {
+			case upper == "L":
+				items = append(items, "the last day")
+			case upper == "LW":
+				items = append(items, "the last weekday")
+			case strings.HasPrefix(upper, "L-"):
+				items = append(items, upper[2:]+" days before the last day")
+			case strings.HasSuffix(upper, "W"):
+				items = append(items, "the weekday nearest day "+upper[:len(upper)-1])
+			default:
+				items = append(items, describeCronList(expr, "day", nil))
+			}
+		}
+		domDesc = "on " + joinEnglish(items) + " of the month"
+	}
+
+	if !dowStar {
+		items := make([]string, 0)
+		ofMonth := false
+		for _, expr := range strings.Split(dow, ",") {
+			upper := strings.ToUpper(expr)
+			switch {
+			case strings.Contains(upper, "#"):
+				parts := strings.SplitN(upper, "#", 2)
+				nth, _ := strconv.Atoi(parts[1])
+				items = append(items, "the "+ordinals[nth]+" "+weekdayName(parts[0]))
+				ofMonth = true
+			case len(upper) > 1 && strings.HasSuffix(upper, "L"):
+				items = append(items, "the last "+weekdayName(upper[:len(upper)-1]))
+				ofMonth = true
+			default:
+				items = append(items, describeCronList(expr, "day of the week", weekdayName))
+			}
+		}
+		dowDesc = "on " + joinEnglish(items)
+		if ofMonth {
+			dowDesc += " of the month"
+		}
+	}
+
+	switch {
+	case domDesc != "" && dowDesc != "":
+		return domDesc + " or " + dowDesc
+	case domDesc != "":
+		return domDesc
+	case dowDesc != "":
+		return dowDesc
+	}
+	return "every day"
+}
+
+// describeMonths explains the month field.
+func describeMonths(month string) string {
+	if month == "*" {
+		return ""
+	}
+	if strings.Contains(month, "/") {
+		return describeCronList(month, "month", monthName)
+	}
+	return "in " + describeCronList(month, "month", monthName)
+}
+
+// describeCronList explains a comma separated list of expressions of a field,
+// with the names of the values if any, e.g. "minutes 0 and 30" or "Monday through Friday".
+func describeCronList(field string, unit string, name func(string) string) string {
+	exprs := strings.Split(field, ",")
+	items := make([]string, 0, len(exprs))
+	plural := len(exprs) > 1
+
+	for _, expr := range exprs {
+		rangeAndStep := strings.SplitN(expr, "/", 2)
+		lowAndHigh := strings.SplitN(rangeAndStep[0], "-", 2)
+
+		var item string
+		if name != nil && lowAndHigh[0] != "*" {
+			item = name(lowAndHigh[0])
+			if len(lowAndHigh) == 2 {
+				item += " through " + name(lowAndHigh[1])
+			}
+		} else if lowAndHigh[0] != "*" {
+			item = lowAndHigh[0]
+			if len(lowAndHigh) == 2 {
+				item += " through " + lowAndHigh[1]
+				plural = true
+			}
+		}
+
+		if len(rangeAndStep) == 2 {
+			every := fmt.Sprintf("every %s %ss", rangeAndStep[1], unit)
+			if rangeAndStep[1] == "1" {
+				every = "every " + unit
+			}
+			switch {
+			case lowAndHigh[0] == "*":
+				item = every
+			case len(lowAndHigh) == 1:
+				item = every + " starting at " + item
+			default:
+				item = every + " from " + item
+			}
+			items = append(items, item)
+			continue
+		}
+
+		if lowAndHigh[0] == "*" {
+			item = "every " + unit
+		}
+		items = append(items, item)
+	}
+
+	list := joinEnglish(items)
+	if name != nil || strings.HasPrefix(list, "every ") {
+		return list
+	}
+	if plural {
+		return unit + "s " + list
+	}
+	return unit + " " + list
+}
+
+func isCronNumber(field string) bool {
+	_, err := strconv.Atoi(field)
+	return err == nil
+}
+
+func weekdayName(value string) string {
+	n, err := parseCronValue(value, dowField)
+	if err != nil {
+		return value
+	}
+	return weekdayNames[n]
+}
+
+func monthName(value string) string {
+	n, err := parseCronValue(value, monthField)
+	if err != nil {
+		return value
+	}
+	return time.Month(n).String()
+}
+
+// joinEnglish joins items as "a, b and c".
+func joinEnglish(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -9,6 +9,18 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// States of an execution.
+const (
+	StateRunning = "running"
+	StateSuccess = "success"
+	StateFailed  = "failed"
+	StateMissed  = "missed"
+	StateSkipped = "skipped"
+)
+
+// ExecutionStates are the states of an execution.
+var ExecutionStates = []string{StateRunning, StateSuccess, StateFailed, StateMissed, StateSkipped}
+
 // Execution type holds all of the details of a specific Execution.
 type Execution struct {
 	mux sync.Mutex
@@ -29,6 +41,15 @@ type Execution struct {
 	// If this execution executed succesfully.
 	Success bool `json:"success,omitempty"`
 
+	// If the schedule of this execution has been missed, it has never been sent to a worker.
+	Missed bool `json:"missed,omitempty"`
+
+	// If the schedule of this execution has been suppressed by a calendar, it has never been sent to a worker.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Name of the calendar which suppressed this execution.
+	Calendar string `json:"calendar,omitempty"`
+
 	// Partial output of the execution.
 	Output []byte `json:"output,omitempty"`
 
@@ -48,6 +69,36 @@ type Execution struct {
 	//forbid: If the job is already running don’t send the execution, it will skip the executions until the next schedule.
 	Concurrency string `json:"concurrency"`
 
+	// one, all or n processors to run this execution on.
+	Target string `json:"target,omitempty"`
+
+	// Number of processors for the n target.
+	TargetCount int `json:"target_count,omitempty"`
+
+	// Selector of the processors allowed to run this execution.
+	NodeSelector *Selector `json:"node_selector,omitempty"`
+
+	// Index of the shard run by this execution, starting from 0.
+	Shard int `json:"shard,omitempty"`
+
+	// Number of shards in the group, 0 if the job isn't sharded.
+	ShardTotal int `json:"shard_total,omitempty"`
+
+	// Progress percentage reported by the worker, from 0 to 100.
+	Progress float64 `json:"progress,omitempty"`
+
+	// Status message reported by the worker.
+	Message string `json:"message,omitempty"`
+
+	// Custom counters reported by the worker, e.g. {"pages": 120}.
+	Counters map[string]int64 `json:"counters,omitempty"`
+
+	// Time of the last progress report of the worker.
+	HeartbeatAt time.Time `json:"heartbeat_at,omitempty"`
+
+	// If the execution has been finished as failed after missing its heartbeats longer than the stall timeout of the job.
+	Stalled bool `json:"stalled,omitempty"`
+
 	// *Job
 
 }
@@ -55,20 +106,82 @@ type Execution struct {
 // NewExecution creates a new execution.
 func NewExecution(j *Job) *Execution {
 	return &Execution{
-		JobName:     j.Name,
-		Payload:     j.Payload,
-		Tags:        j.Tags,
-		Application: j.Application,
-		Group:       time.Now().UnixNano(),
-		Concurrency: j.Concurrency,
-		Attempt:     1,
+		JobName:      j.Name,
+		Payload:      j.Payload,
+		Tags:         j.Tags,
+		Application:  j.Application,
+		Group:        time.Now().UnixNano(),
+		Concurrency:  j.Concurrency,
+		Target:       j.TargetMode(),
+		TargetCount:  j.TargetCount,
+		NodeSelector: j.NodeSelector,
+		Attempt:      1,
 		// Job:     j,
 	}
 }
 
+// NewShardExecutions splits the execution of a sharded job into one execution per shard,
+// all of them belong to the same group.
+func NewShardExecutions(j *Job, ex *Execution) []*Execution {
+	exs := make([]*Execution, 0, j.Shards)
+	for i := 0; i < j.Shards; i++ {
+		payload := make(map[string]string)
+		for k, v := range ex.Payload {
+			payload[k] = v
+		}
+		if i < len(j.ShardParams) {
+			for k, v := range j.ShardParams[i] {
+				payload[k] = v
+			}
+		}
+
+		exs = append(exs, &Execution{
+			JobName:      ex.JobName,
+			Payload:      payload,
+			Tags:         ex.Tags,
+			Application:  ex.Application,
+			Group:        ex.Group,
+			StartedAt:    ex.StartedAt,
+			Concurrency:  ex.Concurrency,
+			Target:       TargetOne,
+			NodeSelector: ex.NodeSelector,
+			Attempt:      ex.Attempt,
+			Shard:        i,
+			ShardTotal:   j.Shards,
+		})
+	}
+
+	return exs
+}
+
+// Ran reports whether the execution has been sent to a worker.
+func (e *Execution) Ran() bool {
+	return !e.Missed && !e.Skipped
+}
+
 // Key wil generate the execution Id for an execution.
+// The keys start with the zero padded start time, so they're ordered by time.
 func (e *Execution) Key() string {
-	return fmt.Sprintf("%d-%s", e.StartedAt.UnixNano(), e.NodeName)
+	// shards of a group may start at the same time on the same node
+	if e.ShardTotal > 0 {
+		return fmt.Sprintf("%019d-%s-%d", e.StartedAt.UnixNano(), e.NodeName, e.Shard)
+	}
+	return fmt.Sprintf("%019d-%s", e.StartedAt.UnixNano(), e.NodeName)
+}
+
+// State returns the state of the execution, one of the ExecutionStates.
+func (e *Execution) State() string {
+	switch {
+	case e.Missed:
+		return StateMissed
+	case e.Skipped:
+		return StateSkipped
+	case e.FinishedAt.IsZero():
+		return StateRunning
+	case e.Success:
+		return StateSuccess
+	}
+	return StateFailed
 }
 
 func (e *Execution) IncCounter(nodeName string, quota string) {
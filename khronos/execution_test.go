@@ -0,0 +1,52 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestNewShardExecutions
+func TestNewShardExecutions(t *testing.T) {
+	job := &Job{
+		Name:        "crawler",
+		Payload:     map[string]string{"market": "spot"},
+		Application: "spider",
+		Shards:      3,
+		ShardParams: []map[string]string{{"coins": "btc,eth"}, {"coins": "eos,xrp", "market": "swap"}},
+	}
+
+	ex := NewExecution(job)
+	ex.StartedAt = time.Now()
+	exs := NewShardExecutions(job, ex)
+	if len(exs) != 3 {
+		t.Fatalf("expected 3 shards got: %d", len(exs))
+	}
+
+	keys := make(map[string]bool)
+	for i, shard := range exs {
+		if shard.Group != ex.Group {
+			t.Fatalf("expected group %d got: %d", ex.Group, shard.Group)
+		}
+		if shard.Shard != i || shard.ShardTotal != 3 {
+			t.Fatalf("expected shard %d/3 got: %d/%d", i, shard.Shard, shard.ShardTotal)
+		}
+		shard.NodeName = "server-001"
+		keys[shard.Key()] = true
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected unique keys for the shards on one node got: %v", keys)
+	}
+
+	if exs[0].Payload["coins"] != "btc,eth" || exs[0].Payload["market"] != "spot" {
+		t.Fatalf("unexpected payload of shard 0: %v", exs[0].Payload)
+	}
+	if exs[1].Payload["market"] != "swap" {
+		t.Fatalf("unexpected payload of shard 1: %v", exs[1].Payload)
+	}
+	if _, ok := exs[2].Payload["coins"]; ok {
+		t.Fatalf("unexpected payload of shard 2: %v", exs[2].Payload)
+	}
+	if _, ok := job.Payload["coins"]; ok {
+		t.Fatalf("job payload has been modified: %v", job.Payload)
+	}
+}
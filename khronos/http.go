@@ -0,0 +1,341 @@
+package khronos
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 of the body of a trigger request, e.g. "sha256=<hex>".
+	SignatureHeader = "X-Khronos-Signature"
+
+	// IdempotencyHeader holds a key of a trigger request, the requests with the same key trigger the job once.
+	IdempotencyHeader = "Idempotency-Key"
+
+	// IdempotencyTTL is how long an idempotency key is remembered.
+	IdempotencyTTL = 24 * time.Hour
+
+	// MaxTriggerBody limits the size of the body of a trigger request.
+	MaxTriggerBody = 1 << 20
+
+	// OutputSeqHeader holds the sequence number of the last chunk of an output response,
+	// to be passed as the after parameter of the next request.
+	OutputSeqHeader = "X-Khronos-Output-Seq"
+
+	// outputPollInterval is how often a followed output is read.
+	outputPollInterval = 500 * time.Millisecond
+)
+
+type httpServer struct {
+	agent *Agent
+}
+
+func listenHTTP(a *Agent) {
+	h := &httpServer{agent: a}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/jobs/", h.jobs)
+	mux.HandleFunc("/v1/executions", h.executions)
+
+	addr := fmt.Sprintf("%s:%d", a.config.BindIP, a.config.BindPort)
+	log.WithFields(log.Fields{
+		"addr": addr,
+	}).Debug("http: Listening")
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.WithFields(log.Fields{
+			"addr": addr,
+			"err":  err,
+		}).Error("http: ListenAndServe fail")
+	}
+}
+
+// jobs routes the requests under /v1/jobs/
+func (h *httpServer) jobs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/"), "/")
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "trigger" {
+		h.trigger(w, r, parts[0])
+		return
+	}
+	if len(parts) == 4 && parts[0] != "" && parts[1] == "executions" && parts[2] != "" && parts[3] == "output" {
+		h.output(w, r, parts[0], parts[2])
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+}
+
+// trigger runs a job with the JSON body of a request signed with the webhook secret of the job
+// POST /v1/jobs/<name>/trigger
+func (h *httpServer) trigger(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxTriggerBody))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	job, err := h.agent.store.GetJob(name)
+	if err == store.ErrKeyNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	// a job without secret has no trigger URL
+	if job.WebhookSecret == "" {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "job not found"})
+		return
+	}
+	if !verifySignature(job.WebhookSecret, body, r.Header.Get(SignatureHeader)) {
+		log.WithFields(log.Fields{
+			"job":    name,
+			"remote": r.RemoteAddr,
+		}).Error("http: Invalid signature of a trigger request")
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid signature"})
+		return
+	}
+
+	payload, err := triggerPayload(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	var idemKey string
+	if key := r.Header.Get(IdempotencyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		idemKey = hex.EncodeToString(sum[:])
+		claimed, err := h.agent.store.ClaimIdempotencyKey(name, idemKey, IdempotencyTTL)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if !claimed {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"job": name, "duplicate": true})
+			return
+		}
+	}
+
+	job.Agent = h.agent
+	if !job.Trigger(payload) {
+		// the job may be retried with the same key once it's runnable
+		if idemKey != "" {
+			if err := h.agent.store.ReleaseIdempotencyKey(name, idemKey); err != nil {
+				log.WithFields(log.Fields{
+					"job": name,
+					"err": err,
+				}).Error("http: ReleaseIdempotencyKey fail")
+			}
+		}
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"job": name, "error": "job isn't runnable now"})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"job": name, "triggered": true})
+}
+
+// output writes the output of an execution after the chunk of the given sequence number,
+// with follow it keeps streaming the chunks as they arrive until the execution finishes.
+// GET /v1/jobs/<name>/executions/<key>/output?after=<seq>&follow=true
+func (h *httpServer) output(w http.ResponseWriter, r *http.Request, name string, key string) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	after := 0
+	if v := r.URL.Query().Get("after"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid after"})
+			return
+		}
+		after = n
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+
+	if _, err := h.agent.store.GetExecution(name, key); err != nil {
+		if err == store.ErrKeyNotFound {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "execution not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	chunks, _, err := h.agent.store.GetOutput(name, key, after)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !follow {
+		if len(chunks) > 0 {
+			after = chunks[len(chunks)-1].Seq
+		}
+		w.Header().Set(OutputSeqHeader, strconv.Itoa(after))
+		for _, c := range chunks {
+			w.Write(c.Data)
+		}
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		for _, c := range chunks {
+			if _, err := w.Write(c.Data); err != nil {
+				return
+			}
+			after = c.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		// stop once the execution has finished and all of its output has been sent
+		if len(chunks) == 0 {
+			ex, err := h.agent.store.GetExecution(name, key)
+			if err != nil || !ex.FinishedAt.IsZero() {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(outputPollInterval):
+		}
+
+		var idx *OutputIndex
+		chunks, idx, err = h.agent.store.GetOutput(name, key, after)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job":       name,
+				"execution": key,
+				"err":       err,
+			}).Error("http: GetOutput fail")
+			return
+		}
+		if idx.Truncated && len(chunks) == 0 && after >= idx.Chunks {
+			return
+		}
+	}
+}
+
+// executions replies a page of the executions matching the query, the latest first
+// GET /v1/executions?job=&node=&status=&group=&from=<RFC3339>&to=<RFC3339>&cursor=&limit=
+func (h *httpServer) executions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	values := r.URL.Query()
+	q := &ExecutionQuery{
+		Job:    values.Get("job"),
+		Node:   values.Get("node"),
+		Status: values.Get("status"),
+		Cursor: values.Get("cursor"),
+	}
+
+	var err error
+	if v := values.Get("group"); v != "" {
+		if q.Group, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid group"})
+			return
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid limit"})
+			return
+		}
+	}
+	if v := values.Get("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid from, expected a RFC3339 time"})
+			return
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid to, expected a RFC3339 time"})
+			return
+		}
+	}
+
+	if err := q.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	page, err := h.agent.store.QueryExecutions(q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// verifySignature checks a "sha256=<hex>" HMAC-SHA256 signature of the body.
+func verifySignature(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// triggerPayload reads the JSON object of a trigger request into a payload,
+// the values which aren't strings are kept in JSON.
+func triggerPayload(body []byte) (map[string]string, error) {
+	payload := make(map[string]string)
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return payload, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("invalid body, expected a JSON object: %s", err)
+	}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			payload[k] = s
+			continue
+		}
+		data, _ := json.Marshal(v)
+		payload[k] = string(data)
+	}
+	return payload, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
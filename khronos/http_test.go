@@ -0,0 +1,80 @@
+package khronos
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestVerifySignature
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"market": "eth"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !verifySignature("secret", body, signature) {
+		t.Fatalf("expected a valid signature")
+	}
+	if verifySignature("other", body, signature) {
+		t.Fatalf("expected an invalid signature of another secret")
+	}
+	if verifySignature("secret", []byte(`{"market": "btc"}`), signature) {
+		t.Fatalf("expected an invalid signature of another body")
+	}
+	if verifySignature("secret", body, hex.EncodeToString(mac.Sum(nil))) || verifySignature("secret", body, "") {
+		t.Fatalf("expected an invalid signature without sha256= prefix")
+	}
+}
+
+//go test -v -run=TestTriggerPayload
+func TestTriggerPayload(t *testing.T) {
+	payload, err := triggerPayload([]byte(`{"market": "eth", "depth": 20, "pairs": ["eth/btc"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["market"] != "eth" || payload["depth"] != "20" || payload["pairs"] != `["eth/btc"]` {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+
+	if payload, err := triggerPayload(nil); err != nil || len(payload) != 0 {
+		t.Fatalf("expected an empty payload got %v, %v", payload, err)
+	}
+	if _, err := triggerPayload([]byte(`[1, 2]`)); err == nil {
+		t.Fatalf("expected an error of a body which isn't an object")
+	}
+}
+
+//go test -v -run=TestHTTPTriggerConflict
+func TestHTTPTriggerConflict(t *testing.T) {
+	a := createTestAgent(createTestStore())
+	h := &httpServer{agent: a}
+
+	j := &Job{Name: "deploy", Application: "spider", WebhookSecret: "secret", EndAt: time.Now().Add(-time.Hour)}
+	if err := a.store.SetJob(j); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+
+	body := `{"ref": "master"}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/deploy/trigger", strings.NewReader(body))
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(IdempotencyHeader, "build-42")
+	w := httptest.NewRecorder()
+	h.jobs(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 out of the window got %d %s", w.Code, w.Body)
+	}
+
+	// the key is released for a retry
+	sum := sha256.Sum256([]byte("build-42"))
+	if claimed, err := a.store.ClaimIdempotencyKey(j.Name, hex.EncodeToString(sum[:]), time.Minute); err != nil || !claimed {
+		t.Fatalf("expected the idempotency key released got %v %v", claimed, err)
+	}
+}
@@ -2,10 +2,14 @@ package khronos
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,6 +28,34 @@ const (
 	ConcurrencyAllow = "allow"
 	// ConcurrencyForbid forbids a job from executing concurrency.
 	ConcurrencyForbid = "forbid"
+	// ConcurrencyReplace cancels the running executions of a job and starts the new one.
+	ConcurrencyReplace = "replace"
+	// ConcurrencyQueue defers the execution of a job until the running one finishes.
+	ConcurrencyQueue = "queue"
+
+	// TargetOne runs every schedule of a job on one processor.
+	TargetOne = "one"
+	// TargetAll broadcasts every schedule of a job to all of the processors.
+	TargetAll = "all"
+	// TargetN runs every schedule of a job on exactly TargetCount processors.
+	TargetN = "n"
+
+	// SuccessAll considers a group successful when all of its executions succeed.
+	SuccessAll = "all"
+	// SuccessAny considers a group successful when any of its executions succeeds.
+	SuccessAny = "any"
+	// SuccessQuorum considers a group successful when more than a half of its executions succeed.
+	SuccessQuorum = "quorum"
+
+	// MisfireSkip skips the schedules missed while the agent was down.
+	MisfireSkip = "skip"
+	// MisfireFireOnce runs a job once for all of its missed schedules.
+	MisfireFireOnce = "fire_once"
+	// MisfireFireAll runs a job for every missed schedule up to the misfire limit.
+	MisfireFireAll = "fire_all"
+
+	// DefaultMisfireLimit is the number of missed schedules run by fire_all when the job has no limit.
+	DefaultMisfireLimit = 10
 )
 
 type Job struct {
@@ -36,6 +68,7 @@ type Job struct {
 	Breif string `json:"breif"`
 
 	// e.g. “@every 1h30m10s” would indicate a schedule that activates every 1 hour, 30 minutes, 10 seconds.
+	// “@at 2018-06-08T10:00:00+08:00” would indicate a schedule that activates once at the given time.
 	Schedule string `json:"schedule"`
 
 	//local:shell,rpc remote:http
@@ -63,10 +96,77 @@ type Job struct {
 
 	//allow (default): Allow concurrent job executions.
 	//forbid: If the job is already running don’t send the execution, it will skip the executions until the next schedule.
+	//replace: If the job is already running cancel the running executions and send the new one.
+	//queue: If the job is already running defer the execution until the running one finishes.
 	Concurrency string `json:"concurrency"`
 
+	// Maximum number of executions of this job running at the same time in the cluster, 0 means no limit.
+	// When it's reached the concurrency policy applies, allow and forbid skip the execution.
+	MaxConcurrent int `json:"max_concurrent"`
+
+	//one: run on one processor.
+	//all: broadcast to all of the processors.
+	//n: run on exactly TargetCount processors.
+	//unset, it's all for the allow concurrency and one for the forbid concurrency as before.
+	Target string `json:"target"`
+
+	// Number of processors for the n target.
+	TargetCount int `json:"target_count"`
+
+	//all (default): the group succeeds if all of the executions succeed.
+	//any: the group succeeds if any of the executions succeeds.
+	//quorum: the group succeeds if more than a half of the executions succeed.
+	SuccessRule string `json:"success_rule"`
+
+	//skip (default): the schedules missed while the agent was down are skipped.
+	//fire_once: run once for all of the missed schedules.
+	//fire_all: run for every missed schedule, at most MisfireLimit of the latest ones.
+	Misfire string `json:"misfire"`
+
+	// Maximum number of missed schedules run by fire_all, 0 means DefaultMisfireLimit.
+	MisfireLimit int `json:"misfire_limit"`
+
+	// A run which can't start within this number of seconds of its schedule is recorded as missed
+	// and isn't started late, 0 means no deadline.
+	StartingDeadline int `json:"starting_deadline"`
+
+	// A running execution without progress report for this number of seconds is stalled,
+	// it's cancelled and finished as failed. 0 means no check.
+	StallTimeout int `json:"stall_timeout"`
+
+	// Which finished executions are kept, the unset limits are taken from the configuration.
+	Retention *Retention `json:"retention"`
+
+	// The job isn't run before this time, zero means it's valid right now.
+	StartAt time.Time `json:"start_at"`
+
+	// The job isn't run after this time, zero means it's valid forever.
+	EndAt time.Time `json:"end_at"`
+
+	// The job is done after this number of successful executions, 0 means no limit.
+	MaxRuns uint `json:"max_runs"`
+
+	// Maximum random delay of every run, e.g. "30s", so the jobs sharing a schedule don't hit the workers at once.
+	Jitter string `json:"jitter"`
+
+	// Delay every run by an offset within the interval of the schedule derived from a hash of the job name,
+	// so the jobs sharing a schedule are staggered the same way at every run.
+	Spread bool `json:"spread"`
+
+	// Trigger the job when a key under this prefix of the keyspace changes, e.g. "markets",
+	// the changed key and value are passed in the payload. A job with a watch doesn't need a schedule.
+	Watch string `json:"watch"`
+
+	// Secret of the HMAC-SHA256 signature of the requests to the trigger URL of the job,
+	// the trigger URL is disabled without a secret.
+	WebhookSecret string `json:"webhook_secret"`
+
+	// Names of the calendars whose blackouts suppress the schedules of this job.
+	Calendars []string `json:"calendars"`
+
 	// Says if a job has been executed right numbers of time
 	// and should not been executed again in the future
+	// e.g. it's set when a one shot job has been fired or after MaxRuns successful executions
 	IsDone bool `json:"is_done"`
 
 	// Meta data about successful and failed runs.
@@ -82,6 +182,26 @@ type Job struct {
 	//the target servers of Application to run this job.
 	Application string `json:"Application"`
 
+	// Only the processors of Application matching the selector can run this job.
+	NodeSelector *Selector `json:"node_selector"`
+
+	// Split every schedule into this number of executions of the same group,
+	// each of them runs on one processor. 0 or 1 means the job isn't sharded.
+	Shards int `json:"shards"`
+
+	// Parameters merged into the payload of the shard of the same index.
+	// e.g. [{"coins": "btc,eth"}, {"coins": "eos,xrp"}]
+	ShardParams []map[string]string `json:"shard_params"`
+
+	// Name of the template the job is an instance of, the job is updated with the template.
+	Template string `json:"template"`
+
+	// Parameters replacing the placeholders of the template.
+	TemplateParams map[string]string `json:"template_params"`
+
+	// Source of the job when it's defined in files, see PlanApply. A managed job is changed by its source only.
+	ManagedBy string `json:"managed_by"`
+
 	Agent *Agent `json:"-"`
 }
 
@@ -93,6 +213,21 @@ type JobMetaData struct {
 	ErrorCount uint `json:"error_count"`
 
 	LastError time.Time `json:"last_error"`
+
+	// The last group of executions of the job, see Execution.Group
+	LastGroup int64 `json:"last_group"`
+
+	// States of the executions of the last group by their keys
+	LastGroupStates map[string]string `json:"last_group_states"`
+
+	// Number of the shards of the last group, zero for a group without shards
+	LastGroupShards int `json:"last_group_shards"`
+
+	// Status of the last group, see Job.Status
+	LastStatus int `json:"last_status"`
+
+	// Number of the running executions of the last group
+	Running int `json:"running"`
 }
 
 // HTTPProperties Custom properties for the remote job type
@@ -115,12 +250,57 @@ type HTTPProperties struct {
 
 // Run the job
 func (j *Job) Run() {
+	scheduled := time.Now()
+	j.fire(scheduled, j.delay(scheduled))
+}
+
+// fire runs the job for the schedule of the given time, the execution is sent after the delay.
+// The blackouts and the starting deadline are checked once the delay is over.
+func (j *Job) fire(scheduled time.Time, delay time.Duration) {
+	// the delay doesn't hold back the other fires of the job
+	if delay > 0 {
+		log.WithFields(log.Fields{
+			"job":   j.Name,
+			"delay": delay,
+		}).Debug("cron > job.Run: delay the run")
+		time.Sleep(delay)
+	}
+
 	j.running.Lock()
 	defer j.running.Unlock()
+
+	if err := j.Agent.store.SetLastScheduled(j.Name, scheduled); err != nil {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+			"err": err,
+		}).Error("cron > job.Run: SetLastScheduled fail")
+	}
+
 	// Maybe we are testing or it's disabled
 	if j.Disabled == false {
+		// the scheduler keeps a job until its next restart, so make sure it isn't done meanwhile
+		if j.MaxRuns > 0 && j.isDone() {
+			log.WithFields(log.Fields{
+				"job":      j.Name,
+				"max_runs": j.MaxRuns,
+			}).Debug("cron > job.Run: job is done")
+			return
+		}
+
+		// the run is due at the end of the delay
+		due := scheduled.Add(delay)
+		if cal := j.Agent.Blackout(j, due); cal != nil {
+			j.recordSkipped(scheduled, cal.Name)
+			return
+		}
+
+		if j.StartingDeadline > 0 && time.Since(due) > time.Duration(j.StartingDeadline)*time.Second {
+			j.recordMissed(scheduled, fmt.Sprintf("missed: not started within %ds of its schedule", j.StartingDeadline))
+			return
+		}
+
 		// Check if it's runnable
-		if j.isRunnable() {
+		if j.isRunnable(scheduled, true) {
 			log.WithFields(log.Fields{
 				"job":         j.Name,
 				"schedule":    j.Schedule,
@@ -128,34 +308,341 @@ func (j *Job) Run() {
 				"application": j.Application,
 			}).Debug("cron > job.Run: run a job")
 
-			ex := NewExecution(j)
-			ex.StartedAt = time.Now()
-			j.Agent.Do(ex)
+			j.dispatch(nil)
 		}
 	}
 }
 
-func (j *Job) isRunnable() bool {
-	status := j.Status()
+// Trigger runs the job out of its schedule, e.g. on the change of a watched key,
+// the payload is merged into the payload of the execution. It reports whether an execution has been sent.
+// A trigger isn't queued behind the running executions, the deferred schedules don't keep a payload.
+func (j *Job) Trigger(payload map[string]string) bool {
+	j.running.Lock()
+	defer j.running.Unlock()
 
-	if status == Running {
-		if j.Concurrency == ConcurrencyAllow {
-			return true
-		} else if j.Concurrency == ConcurrencyForbid {
+	if j.Disabled {
+		return false
+	}
+
+	// a one shot job or a job which has reached its max runs
+	if j.isDone() {
+		log.WithFields(log.Fields{
+			"job":      j.Name,
+			"max_runs": j.MaxRuns,
+		}).Debug("job.Trigger: job is done")
+		return false
+	}
+
+	now := time.Now()
+	if !j.inWindow(now) {
+		log.WithFields(log.Fields{
+			"job":      j.Name,
+			"start_at": j.StartAt,
+			"end_at":   j.EndAt,
+		}).Debug("job.Trigger: out of the window of the job")
+		return false
+	}
+
+	if cal := j.Agent.Blackout(j, now); cal != nil {
+		j.recordSkipped(now, cal.Name)
+		return false
+	}
+
+	if !j.isRunnable(now, false) {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"job":         j.Name,
+		"payload":     payload,
+		"application": j.Application,
+	}).Debug("job.Trigger: run a job")
+
+	return j.dispatch(payload)
+}
+
+// claim marks a one shot job done right before it's run, it reports false when another agent has run it,
+// so a skipped or queued schedule leaves the job to its next fire.
+func (j *Job) claim() bool {
+	if !j.IsOneShot() {
+		return true
+	}
+
+	claimed, err := j.Agent.store.SetJobDone(j.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+			"err": err,
+		}).Error("cron > job.Run: SetJobDone fail")
+		return false
+	}
+	if !claimed {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+		}).Debug("cron > job.Run: one shot job has already been run")
+		return false
+	}
+	j.IsDone = true
+	return true
+}
+
+// dispatch sends a new execution of the job, or its shards, with the payload merged into the job's.
+// With max concurrent the execution takes a slot of the job first, and a one shot job is claimed last.
+// It reports false when nothing has been sent.
+func (j *Job) dispatch(payload map[string]string) bool {
+	ex := NewExecution(j)
+	ex.StartedAt = time.Now()
+
+	if j.MaxConcurrent > 0 {
+		taken, err := j.Agent.store.TakeSlot(j.Name, ex.Group, j.MaxConcurrent)
+		if err != nil {
 			log.WithFields(log.Fields{
-				"job":         j.Name,
-				"concurrency": j.Concurrency,
-				"job_status":  status,
-			}).Debug("scheduler > job.isRunnable: Skipping execution")
+				"job": j.Name,
+				"err": err,
+			}).Error("job.dispatch: TakeSlot fail")
+			return false
+		}
+		if !taken {
+			log.WithFields(log.Fields{
+				"job":            j.Name,
+				"max_concurrent": j.MaxConcurrent,
+			}).Debug("job.dispatch: Skipping execution, all of the slots are taken")
 			return false
 		}
 	}
+	if !j.claim() {
+		if j.MaxConcurrent > 0 {
+			j.Agent.releaseSlot(ex)
+		}
+		return false
+	}
 
-	return true
+	if len(payload) > 0 {
+		merged := make(map[string]string)
+		for k, v := range j.Payload {
+			merged[k] = v
+		}
+		for k, v := range payload {
+			merged[k] = v
+		}
+		ex.Payload = merged
+	}
+
+	var sent bool
+	if j.Shards > 1 {
+		sent = j.Agent.DoShards(NewShardExecutions(j, ex))
+	} else {
+		sent = j.Agent.Do(ex)
+	}
+	// nothing runs, the slot is free again
+	if !sent && j.MaxConcurrent > 0 {
+		j.Agent.releaseSlot(ex)
+	}
+	return sent
+}
+
+// delay returns the spread offset and a random jitter of the run of the given schedule.
+func (j *Job) delay(scheduled time.Time) time.Duration {
+	var d time.Duration
+
+	if j.Spread {
+		if sched, err := j.CronSchedule(); err == nil {
+			d += j.spreadDelay(sched, scheduled)
+		}
+	}
+
+	if j.Jitter != "" {
+		jitter, err := time.ParseDuration(j.Jitter)
+		if err == nil && jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(jitter)))
+		}
+	}
+
+	return d
+}
+
+// spreadDelay returns the spread offset of the run of the given schedule, taken within the length
+// of a slot of the schedule whatever the time of the run in its slot.
+func (j *Job) spreadDelay(sched cron.Schedule, scheduled time.Time) time.Duration {
+	if !j.Spread {
+		return 0
+	}
+	if next := sched.Next(scheduled); !next.IsZero() {
+		if after := sched.Next(next); !after.IsZero() {
+			return spreadOffset(j.Name, after.Sub(next))
+		}
+	}
+	return 0
+}
+
+// spreadOffset derives an offset within the interval from a hash of the name.
+func spreadOffset(name string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// isDone reports whether the stored job has been done
+func (j *Job) isDone() bool {
+	job, err := j.Agent.store.GetJob(j.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+			"err": err,
+		}).Error("cron > job.Run: GetJob fail")
+		return false
+	}
+	return job.IsDone
+}
+
+// CronSchedule parses the schedule of the job restricted to its validity window.
+func (j *Job) CronSchedule() (cron.Schedule, error) {
+	sched, err := ParseSchedule(j.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// a one shot job simply activates at its time
+	if _, ok := sched.(*AtSchedule); ok {
+		return sched, nil
+	}
+
+	if j.StartAt.IsZero() && j.EndAt.IsZero() {
+		return sched, nil
+	}
+	return &BoundedSchedule{Schedule: sched, StartAt: j.StartAt, EndAt: j.EndAt}, nil
+}
+
+// inWindow reports whether t is within the start_at and end_at of the job.
+func (j *Job) inWindow(t time.Time) bool {
+	if !j.StartAt.IsZero() && t.Before(j.StartAt) {
+		return false
+	}
+	return j.EndAt.IsZero() || !t.After(j.EndAt)
+}
+
+// IsOneShot reports whether the job runs only once, with an @at or the former @oneway schedule.
+func (j *Job) IsOneShot() bool {
+	schedule := strings.TrimSpace(j.Schedule)
+	return schedule == "@oneway" || strings.HasPrefix(schedule, "@at ")
+}
+
+// recordMissed stores an execution for a schedule which hasn't been run in time
+func (j *Job) recordMissed(scheduled time.Time, reason string) {
+	ex := j.notRun(scheduled, reason)
+	ex.Missed = true
+	j.saveNotRun(ex)
+}
+
+// recordSkipped stores an execution for a schedule suppressed by a calendar
+func (j *Job) recordSkipped(scheduled time.Time, calendar string) {
+	ex := j.notRun(scheduled, fmt.Sprintf("skipped: blackout of calendar %s", calendar))
+	ex.Skipped = true
+	ex.Calendar = calendar
+	j.saveNotRun(ex)
+}
+
+// notRun returns a finished execution for a schedule which hasn't been sent to a worker
+func (j *Job) notRun(scheduled time.Time, reason string) *Execution {
+	ex := NewExecution(j)
+	ex.StartedAt = scheduled
+	ex.FinishedAt = time.Now()
+	ex.NodeName = j.Agent.config.NodeName
+	ex.Output = []byte(reason)
+	return ex
+}
+
+func (j *Job) saveNotRun(ex *Execution) {
+	log.WithFields(log.Fields{
+		"job":       j.Name,
+		"scheduled": ex.StartedAt,
+		"reason":    string(ex.Output),
+	}).Info("cron > job.Run: schedule not run")
+
+	if _, err := j.Agent.store.SetExecution(ex); err != nil {
+		log.WithFields(log.Fields{
+			"job": j.Name,
+			"err": err,
+		}).Error("cron > job.Run: SetExecution of a schedule not run fail")
+	}
+}
+
+// isRunnable applies the concurrency policy of the job, with queue a busy job with the queue policy
+// defers the schedule, otherwise it's skipped.
+func (j *Job) isRunnable(scheduled time.Time, queue bool) bool {
+	busy := false
+	if j.MaxConcurrent > 0 {
+		// the limit is shared by all of the agents through the store
+		running, err := j.Agent.store.GetRunningExecutions(j.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job": j.Name,
+				"err": err,
+			}).Error("scheduler > job.isRunnable: GetRunningExecutions fail")
+		}
+		busy = len(running) >= j.MaxConcurrent
+	} else if j.Concurrency == ConcurrencyForbid || j.Concurrency == ConcurrencyReplace || j.Concurrency == ConcurrencyQueue {
+		busy = j.Status() == Running
+	}
+
+	if !busy {
+		return true
+	}
+
+	switch j.Concurrency {
+	case ConcurrencyReplace:
+		log.WithFields(log.Fields{
+			"job":         j.Name,
+			"concurrency": j.Concurrency,
+		}).Debug("scheduler > job.isRunnable: Replacing running executions")
+		j.Agent.CancelRunning(j.Name)
+		return true
+
+	case ConcurrencyQueue:
+		if !queue {
+			break
+		}
+		log.WithFields(log.Fields{
+			"job":         j.Name,
+			"concurrency": j.Concurrency,
+		}).Debug("scheduler > job.isRunnable: Queueing execution")
+		if err := j.Agent.store.QueueJob(j.Name, scheduled); err != nil {
+			log.WithFields(log.Fields{
+				"job": j.Name,
+				"err": err,
+			}).Error("scheduler > job.isRunnable: QueueJob fail")
+		}
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"job":            j.Name,
+		"concurrency":    j.Concurrency,
+		"max_concurrent": j.MaxConcurrent,
+	}).Debug("scheduler > job.isRunnable: Skipping execution")
+	return false
 }
 
 // Status returns the status of a job whether it's running, succeded or failed
 func (j *Job) Status() int {
+	m, err := j.Agent.store.GetJobMetadata(j.Name)
+	if err != nil {
+		return groupStatus(0, 0, j.SuccessRule)
+	}
+	// the jobs which haven't run since the last group was tracked in the metadata
+	if m.LastGroup == 0 {
+		return j.scanStatus()
+	}
+	// by the success rule of the job as it is now
+	m.summarize(j.SuccessRule)
+	return m.LastStatus
+}
+
+// scanStatus aggregates the status of the last group from its executions.
+func (j *Job) scanStatus() int {
 	execs, _ := j.Agent.store.GetLastExecutionGroup(j.Name)
 	success := 0
 	failed := 0
@@ -165,7 +652,6 @@ func (j *Job) Status() int {
 		}
 	}
 
-	var status int
 	for _, ex := range execs {
 		if ex.Success {
 			success = success + 1
@@ -174,15 +660,116 @@ func (j *Job) Status() int {
 		}
 	}
 
+	// shards which haven't been dispatched count as failed
+	if len(execs) > 0 && execs[0].ShardTotal > len(execs) {
+		failed = failed + execs[0].ShardTotal - len(execs)
+	}
+
+	return groupStatus(success, failed, j.SuccessRule)
+}
+
+// TargetMode returns the target of the job,
+// which is derived from the concurrency for the jobs created before the target existed.
+func (j *Job) TargetMode() string {
+	if j.Target != "" {
+		return j.Target
+	}
+	if j.Concurrency == ConcurrencyForbid {
+		return TargetOne
+	}
+	return TargetAll
+}
+
+// track records the transition of an execution of the job from its former state in the last group,
+// a nil state is a new or a deleted execution. It reports whether the metadata has changed.
+func (m *JobMetaData) track(prev *Execution, ex *Execution, rule string) bool {
+	cur := ex
+	if cur == nil {
+		cur = prev
+	}
+	// the executions which haven't been run don't make the groups of a job
+	if cur == nil || !cur.Ran() || cur.Group < m.LastGroup {
+		return false
+	}
+
+	if cur.Group > m.LastGroup {
+		if ex == nil {
+			return false
+		}
+		m.LastGroup = cur.Group
+		m.LastGroupStates = make(map[string]string)
+		m.LastGroupShards = cur.ShardTotal
+	}
+	if m.LastGroupStates == nil {
+		m.LastGroupStates = make(map[string]string)
+	}
+
+	key := cur.Key()
+	state, ok := m.LastGroupStates[key]
+	if ex == nil {
+		if !ok {
+			return false
+		}
+		delete(m.LastGroupStates, key)
+	} else {
+		if ok && state == ex.State() {
+			return false
+		}
+		m.LastGroupStates[key] = ex.State()
+	}
+
+	m.summarize(rule)
+	return true
+}
+
+// summarize aggregates the status of the last group from the states of its executions.
+func (m *JobMetaData) summarize(rule string) {
+	success := 0
+	failed := 0
+	m.Running = 0
+	for _, state := range m.LastGroupStates {
+		switch state {
+		case StateRunning:
+			m.Running = m.Running + 1
+		case StateSuccess:
+			success = success + 1
+		default:
+			failed = failed + 1
+		}
+	}
+	if m.Running > 0 {
+		m.LastStatus = Running
+		return
+	}
+
+	// shards which haven't been dispatched count as failed
+	if m.LastGroupShards > len(m.LastGroupStates) {
+		failed = failed + m.LastGroupShards - len(m.LastGroupStates)
+	}
+	m.LastStatus = groupStatus(success, failed, rule)
+}
+
+// groupStatus aggregates the results of the finished executions of a group by a success rule.
+func groupStatus(success int, failed int, rule string) int {
 	if failed == 0 {
-		status = Success
-	} else if failed > 0 && success == 0 {
-		status = Failed
-	} else if failed > 0 && success > 0 {
-		status = PartialyFailed
+		return Success
 	}
 
-	return status
+	switch rule {
+	case SuccessAny:
+		if success > 0 {
+			return Success
+		}
+	case SuccessQuorum:
+		if success*2 > success+failed {
+			return Success
+		}
+	}
+
+	if success == 0 {
+		return Failed
+	}
+	return PartialyFailed
 }
 
 // Friendly format a job
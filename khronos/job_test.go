@@ -0,0 +1,256 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestGroupStatus
+func TestGroupStatus(t *testing.T) {
+	cases := []struct {
+		success  int
+		failed   int
+		rule     string
+		expected int
+	}{
+		{0, 0, "", Success},
+		{3, 0, SuccessAll, Success},
+		{2, 1, SuccessAll, PartialyFailed},
+		{0, 3, SuccessAll, Failed},
+		{1, 2, SuccessAny, Success},
+		{0, 3, SuccessAny, Failed},
+		{2, 1, SuccessQuorum, Success},
+		{1, 1, SuccessQuorum, PartialyFailed},
+		{0, 2, SuccessQuorum, Failed},
+	}
+
+	for _, c := range cases {
+		if got := groupStatus(c.success, c.failed, c.rule); got != c.expected {
+			t.Fatalf("rule %s with %d success and %d failed: expected %d got %d", c.rule, c.success, c.failed, c.expected, got)
+		}
+	}
+}
+
+//go test -v -run=TestTargetMode
+func TestTargetMode(t *testing.T) {
+	cases := []struct {
+		job      *Job
+		expected string
+	}{
+		{&Job{}, TargetAll},
+		{&Job{Concurrency: ConcurrencyAllow}, TargetAll},
+		{&Job{Concurrency: ConcurrencyForbid}, TargetOne},
+		{&Job{Concurrency: ConcurrencyAllow, Target: TargetOne}, TargetOne},
+		{&Job{Concurrency: ConcurrencyForbid, Target: TargetN, TargetCount: 2}, TargetN},
+	}
+
+	for _, c := range cases {
+		if got := c.job.TargetMode(); got != c.expected {
+			t.Fatalf("expected target %s got %s for %+v", c.expected, got, c.job)
+		}
+	}
+}
+
+//go test -v -run=TestJobDelay
+func TestJobDelay(t *testing.T) {
+	scheduled := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+
+	a := &Job{Name: "spider-a", Schedule: "0 0 * * * *", Spread: true}
+	b := &Job{Name: "spider-b", Schedule: "0 0 * * * *", Spread: true}
+	da, db := a.delay(scheduled), b.delay(scheduled)
+	if da < 0 || da >= time.Hour || db < 0 || db >= time.Hour {
+		t.Fatalf("expected delays within the hour got %s, %s", da, db)
+	}
+	if da == db {
+		t.Fatalf("expected the jobs staggered got %s", da)
+	}
+	if again := a.delay(scheduled.Add(time.Hour)); again != da {
+		t.Fatalf("expected the same delay at every run got %s, %s", da, again)
+	}
+	// a run fired late in its slot keeps its offset
+	if late := a.delay(scheduled.Add(17*time.Minute + 350*time.Millisecond)); late != da {
+		t.Fatalf("expected the same delay for a run late in its slot got %s, %s", da, late)
+	}
+
+	j := &Job{Name: "jitter", Schedule: "@every 5s", Jitter: "2s"}
+	for i := 0; i < 100; i++ {
+		if d := j.delay(scheduled); d < 0 || d >= 2*time.Second {
+			t.Fatalf("expected a delay up to 2s got %s", d)
+		}
+	}
+
+	if d := (&Job{Name: "none", Schedule: "@every 5s"}).delay(scheduled); d != 0 {
+		t.Fatalf("expected no delay got %s", d)
+	}
+}
+
+//go test -v -run=TestTrackLastGroup
+func TestTrackLastGroup(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+	var m JobMetaData
+
+	first := &Execution{JobName: "spider", NodeName: "node1", Group: now.UnixNano(), StartedAt: now}
+	second := &Execution{JobName: "spider", NodeName: "node2", Group: now.UnixNano(), StartedAt: now}
+	if !m.track(nil, first, SuccessAll) || !m.track(nil, second, SuccessAll) {
+		t.Fatalf("expected the new executions tracked")
+	}
+	if m.LastStatus != Running || m.Running != 2 {
+		t.Fatalf("expected 2 running executions got status %d running %d", m.LastStatus, m.Running)
+	}
+
+	// a repeated state changes nothing
+	if m.track(first, first, SuccessAll) {
+		t.Fatalf("expected an unchanged state ignored")
+	}
+
+	done := &Execution{JobName: "spider", NodeName: "node1", Group: now.UnixNano(), StartedAt: now, FinishedAt: now.Add(time.Minute), Success: true}
+	m.track(first, done, SuccessAll)
+	if m.LastStatus != Running || m.Running != 1 {
+		t.Fatalf("expected 1 running execution got status %d running %d", m.LastStatus, m.Running)
+	}
+
+	failed := &Execution{JobName: "spider", NodeName: "node2", Group: now.UnixNano(), StartedAt: now, FinishedAt: now.Add(time.Minute)}
+	m.track(second, failed, SuccessAll)
+	if m.LastStatus != PartialyFailed || m.Running != 0 {
+		t.Fatalf("expected partialy failed got status %d running %d", m.LastStatus, m.Running)
+	}
+
+	// the executions of a former group and the missed ones don't count
+	old := &Execution{JobName: "spider", NodeName: "node1", Group: now.Add(-time.Hour).UnixNano(), StartedAt: now.Add(-time.Hour)}
+	missed := &Execution{JobName: "spider", Group: now.Add(time.Hour).UnixNano(), StartedAt: now.Add(time.Hour), Missed: true}
+	if m.track(nil, old, SuccessAll) || m.track(nil, missed, SuccessAll) {
+		t.Fatalf("expected the former and the missed executions ignored")
+	}
+
+	// the deleted failure of the node gone
+	m.track(failed, nil, SuccessAll)
+	if m.LastStatus != Success || len(m.LastGroupStates) != 1 {
+		t.Fatalf("expected success got status %d states %v", m.LastStatus, m.LastGroupStates)
+	}
+
+	// a new group with shards which haven't been dispatched
+	shard := &Execution{JobName: "spider", NodeName: "node1", Group: now.Add(time.Hour).UnixNano(), StartedAt: now.Add(time.Hour),
+		FinishedAt: now.Add(time.Hour + time.Minute), Success: true, Shard: 0, ShardTotal: 3}
+	m.track(nil, shard, SuccessQuorum)
+	if m.LastGroup != shard.Group || m.LastStatus != PartialyFailed {
+		t.Fatalf("expected the new group partialy failed got group %d status %d", m.LastGroup, m.LastStatus)
+	}
+}
+
+//go test -v -run=TestOneShotBlackout
+func TestOneShotBlackout(t *testing.T) {
+	s := createTestStore()
+	a := createTestAgent(s)
+
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	cal := &Calendar{Name: "freeze", Events: []CalendarEvent{{Summary: "freeze", Start: at.Add(-time.Minute), End: at.Add(time.Minute)}}}
+	if err := s.SetCalendar(cal); err != nil {
+		t.Fatalf("error setting calendar: %s", err)
+	}
+	j := &Job{Name: "release", Schedule: "@at " + at.Format(time.RFC3339), Application: "spider", Calendars: []string{"freeze"}}
+	if err := s.SetJob(j); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	j.Agent = a
+
+	// the blacked out schedule doesn't use up the one shot
+	j.fire(at, 0)
+	if stored, _ := s.GetJob(j.Name); stored.IsDone {
+		t.Fatalf("expected the job not done after a blackout")
+	}
+	if exs, _ := s.GetExecutions(j.Name); len(exs) != 1 || !exs[0].Skipped {
+		t.Fatalf("expected a skipped execution got %v", exs)
+	}
+
+	// fired again after the blackout, e.g. caught up by a restarted agent, it runs once
+	j.fire(at.Add(2*time.Minute), 0)
+	if stored, _ := s.GetJob(j.Name); !stored.IsDone || !j.IsDone {
+		t.Fatalf("expected the job done once run")
+	}
+}
+
+//go test -v -run=TestDelayedBlackout
+func TestDelayedBlackout(t *testing.T) {
+	s := createTestStore()
+	a := createTestAgent(s)
+
+	// the blackout starts while the run is delayed
+	scheduled := time.Now()
+	delay := 100 * time.Millisecond
+	cal := &Calendar{Name: "freeze", Events: []CalendarEvent{{Summary: "freeze", Start: scheduled.Add(delay / 2), End: scheduled.Add(time.Hour)}}}
+	if err := s.SetCalendar(cal); err != nil {
+		t.Fatalf("error setting calendar: %s", err)
+	}
+	j := &Job{Name: "spider", Schedule: "@every 5s", Application: "spider", Calendars: []string{"freeze"}, Agent: a}
+
+	j.fire(scheduled, delay)
+	if exs, _ := s.GetExecutions(j.Name); len(exs) != 1 || !exs[0].Skipped {
+		t.Fatalf("expected the delayed run skipped got %v", exs)
+	}
+}
+
+//go test -v -run=TestTriggerNotRunnable
+func TestTriggerNotRunnable(t *testing.T) {
+	s := createTestStore()
+	a := createTestAgent(s)
+
+	// a busy job with the queue policy isn't queued by a trigger
+	j := &Job{Name: "spider", Schedule: "@every 5s", Concurrency: ConcurrencyQueue, Application: "spider"}
+	if err := s.SetJob(j); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	now := time.Now()
+	if _, err := s.SetExecution(&Execution{JobName: j.Name, NodeName: "node1", Group: now.UnixNano(), StartedAt: now}); err != nil {
+		t.Fatalf("error setting execution: %s", err)
+	}
+	j.Agent = a
+	if j.Trigger(map[string]string{"market": "eth"}) {
+		t.Fatalf("expected a busy job not triggered")
+	}
+	if queued, _ := s.DequeueJob(j.Name); !queued.IsZero() {
+		t.Fatalf("expected nothing queued by a trigger got %s", queued)
+	}
+
+	// a done one shot job isn't triggered
+	at := &Job{Name: "release", Schedule: "@at " + now.Format(time.RFC3339), Application: "spider"}
+	if err := s.SetJob(at); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	if ok, err := s.SetJobDone(at.Name); err != nil || !ok {
+		t.Fatalf("error setting job done: %v", err)
+	}
+	at.Agent = a
+	if at.Trigger(nil) {
+		t.Fatalf("expected a done one shot job not triggered")
+	}
+
+	// a trigger is bound to the window of the job
+	for _, w := range []*Job{
+		{Name: "early", Application: "spider", StartAt: now.Add(time.Hour)},
+		{Name: "late", Application: "spider", EndAt: now.Add(-time.Hour)},
+	} {
+		w.Agent = a
+		if w.Trigger(nil) {
+			t.Fatalf("expected %s out of its window not triggered", w.Name)
+		}
+	}
+}
+
+//go test -v -run=TestTriggerNotSent
+func TestTriggerNotSent(t *testing.T) {
+	a := createTestAgent(createTestStore())
+
+	// no processor runs the job
+	j := &Job{Name: "spider", Application: "spider", MaxConcurrent: 1, Agent: a}
+	if err := a.store.SetJob(j); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	if j.Trigger(nil) {
+		t.Fatalf("expected a job without processor not triggered")
+	}
+
+	// its slot is free again
+	if taken, err := a.store.TakeSlot(j.Name, time.Now().UnixNano(), j.MaxConcurrent); err != nil || !taken {
+		t.Fatalf("expected the slot released got %v %v", taken, err)
+	}
+}
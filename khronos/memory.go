@@ -0,0 +1,352 @@
+package khronos
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+)
+
+// Memory is the backend keeping the keyspace in the memory of the agent,
+// for the tests and a single agent whose jobs don't need to survive a restart.
+const Memory store.Backend = "memory"
+
+// memoryKV is a store.Store in memory with the atomic operations, the watches and the TTLs of the backends.
+type memoryKV struct {
+	mu sync.Mutex
+
+	// incremented by every change, the LastIndex of the pairs
+	index uint64
+
+	entries  map[string]*memoryEntry
+	watchers map[*memoryWatcher]struct{}
+
+	done   chan struct{}
+	closed bool
+}
+
+type memoryEntry struct {
+	value   []byte
+	index   uint64
+	expires time.Time
+}
+
+// memoryWatcher is notified of the changes of a key, or of the keys under a directory.
+type memoryWatcher struct {
+	key    string
+	tree   bool
+	notify chan struct{}
+}
+
+// NewMemoryKV creates an empty store in memory, registered as the Memory backend.
+func NewMemoryKV(addrs []string, options *store.Config) (store.Store, error) {
+	return &memoryKV{
+		entries:  make(map[string]*memoryEntry),
+		watchers: make(map[*memoryWatcher]struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// NewMemoryStore creates a store of the keyspace in memory.
+func NewMemoryStore(keyspace string) *Store {
+	kv, _ := NewMemoryKV(nil, nil)
+	return &Store{Client: kv, keyspace: keyspace, backend: string(Memory)}
+}
+
+// normalizeKey removes the leading, the trailing and the repeated slashes of a key like the etcd v3 backend.
+func normalizeKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+func (m *memoryKV) Put(key string, value []byte, options *store.WriteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(normalizeKey(key), value, options)
+	return nil
+}
+
+func (m *memoryKV) Get(key string, options *store.ReadOptions) (*store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalizeKey(key)
+	e := m.lookup(key)
+	if e == nil {
+		return nil, store.ErrKeyNotFound
+	}
+	return e.pair(key), nil
+}
+
+func (m *memoryKV) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalizeKey(key)
+	if m.lookup(key) != nil {
+		m.remove(key)
+	}
+	return nil
+}
+
+func (m *memoryKV) Exists(key string, options *store.ReadOptions) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lookup(normalizeKey(key)) != nil, nil
+}
+
+// Watch sends the pair of the key as it is, then every time it changes,
+// a deleted key is sent without value.
+func (m *memoryKV) Watch(key string, stopCh <-chan struct{}, options *store.ReadOptions) (<-chan *store.KVPair, error) {
+	key = normalizeKey(key)
+	if ok, _ := m.Exists(key, nil); !ok {
+		return nil, store.ErrKeyNotFound
+	}
+
+	w := m.addWatcher(key, false)
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		defer m.removeWatcher(w)
+		for {
+			m.mu.Lock()
+			pair := &store.KVPair{Key: key, LastIndex: m.index}
+			if e := m.lookup(key); e != nil {
+				pair = e.pair(key)
+			}
+			m.mu.Unlock()
+
+			select {
+			case out <- pair:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+			if !m.wait(w, stopCh) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree sends the pairs under the directory as they are, then every time one of them changes.
+// The changes made while the last pairs haven't been received are sent at once.
+func (m *memoryKV) WatchTree(directory string, stopCh <-chan struct{}, options *store.ReadOptions) (<-chan []*store.KVPair, error) {
+	directory = normalizeKey(directory)
+
+	w := m.addWatcher(directory, true)
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		defer m.removeWatcher(w)
+		for {
+			m.mu.Lock()
+			pairs := m.list(directory)
+			m.mu.Unlock()
+
+			select {
+			case out <- pairs:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+			if !m.wait(w, stopCh) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// NewLock isn't supported, khronos coordinates by the atomic operations.
+func (m *memoryKV) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memoryKV) List(directory string, options *store.ReadOptions) ([]*store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pairs := m.list(normalizeKey(directory))
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+func (m *memoryKV) DeleteTree(directory string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	directory = normalizeKey(directory)
+	for key := range m.entries {
+		if key == directory || isUnder(key, directory) {
+			m.remove(key)
+		}
+	}
+	return nil
+}
+
+// AtomicPut puts the value if the key hasn't changed since the previous pair was read,
+// without previous pair it's put only if the key doesn't exist.
+func (m *memoryKV) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalizeKey(key)
+	e := m.lookup(key)
+	switch {
+	case previous == nil && e != nil:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && e == nil:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && e.index != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+
+	return true, m.set(key, value, options).pair(key), nil
+}
+
+// AtomicDelete deletes the key if it hasn't changed since the previous pair was read.
+func (m *memoryKV) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalizeKey(key)
+	e := m.lookup(key)
+	if e == nil {
+		return false, store.ErrKeyNotFound
+	}
+	if e.index != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	m.remove(key)
+	return true, nil
+}
+
+// Close ends the watches.
+func (m *memoryKV) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+}
+
+// lookup returns the entry of a key unless it doesn't exist or it has expired.
+func (m *memoryKV) lookup(key string) *memoryEntry {
+	e, ok := m.entries[key]
+	if !ok || (!e.expires.IsZero() && !time.Now().Before(e.expires)) {
+		return nil
+	}
+	return e
+}
+
+func (m *memoryKV) set(key string, value []byte, options *store.WriteOptions) *memoryEntry {
+	m.index++
+	e := &memoryEntry{value: append([]byte(nil), value...), index: m.index}
+	if options != nil && options.TTL > 0 {
+		e.expires = time.Now().Add(options.TTL)
+		index := e.index
+		time.AfterFunc(options.TTL, func() { m.expire(key, index) })
+	}
+	m.entries[key] = e
+	m.changed(key)
+	return e
+}
+
+func (m *memoryKV) remove(key string) {
+	m.index++
+	delete(m.entries, key)
+	m.changed(key)
+}
+
+// expire removes a key put with a TTL unless it has been put again since.
+func (m *memoryKV) expire(key string, index uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && e.index == index {
+		m.remove(key)
+	}
+}
+
+// list returns the pairs under the directory ordered by their keys.
+func (m *memoryKV) list(directory string) []*store.KVPair {
+	keys := make([]string, 0)
+	for key := range m.entries {
+		if isUnder(key, directory) && m.lookup(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]*store.KVPair, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, m.entries[key].pair(key))
+	}
+	return pairs
+}
+
+// changed notifies the watchers of the key.
+func (m *memoryKV) changed(key string) {
+	for w := range m.watchers {
+		if (w.tree && isUnder(key, w.key)) || (!w.tree && w.key == key) {
+			select {
+			case w.notify <- struct{}{}:
+			default:
+				// the watcher hasn't caught up with the former change yet
+			}
+		}
+	}
+}
+
+func (m *memoryKV) addWatcher(key string, tree bool) *memoryWatcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &memoryWatcher{key: key, tree: tree, notify: make(chan struct{}, 1)}
+	m.watchers[w] = struct{}{}
+	return w
+}
+
+func (m *memoryKV) removeWatcher(w *memoryWatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.watchers, w)
+}
+
+// wait waits for a change notified to the watcher, it reports false once the watch is stopped.
+func (m *memoryKV) wait(w *memoryWatcher, stopCh <-chan struct{}) bool {
+	select {
+	case <-w.notify:
+		return true
+	case <-stopCh:
+		return false
+	case <-m.done:
+		return false
+	}
+}
+
+func (e *memoryEntry) pair(key string) *store.KVPair {
+	return &store.KVPair{Key: key, Value: append([]byte(nil), e.value...), LastIndex: e.index}
+}
+
+// isUnder reports whether the key is under the directory, the root directory is empty.
+func isUnder(key string, directory string) bool {
+	return directory == "" || strings.HasPrefix(key, directory+"/")
+}
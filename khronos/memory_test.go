@@ -0,0 +1,118 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+)
+
+//go test -v -run=TestMemoryAtomicPut
+func TestMemoryAtomicPut(t *testing.T) {
+	kv, _ := NewMemoryKV(nil, nil)
+
+	if _, _, err := kv.AtomicPut("/khronos/jobs/spider", []byte("v1"), nil, nil); err != nil {
+		t.Fatalf("error creating key: %s", err)
+	}
+	if _, _, err := kv.AtomicPut("/khronos/jobs/spider", []byte("v1"), nil, nil); err != store.ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists got %v", err)
+	}
+
+	pair, err := kv.Get("khronos/jobs/spider/", nil)
+	if err != nil || string(pair.Value) != "v1" {
+		t.Fatalf("expected v1 got %v %v", pair, err)
+	}
+	if _, _, err := kv.AtomicPut("/khronos/jobs/spider", []byte("v2"), pair, nil); err != nil {
+		t.Fatalf("error updating key: %s", err)
+	}
+	if _, _, err := kv.AtomicPut("/khronos/jobs/spider", []byte("v3"), pair, nil); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified got %v", err)
+	}
+	if _, err := kv.AtomicDelete("/khronos/jobs/spider", pair); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified got %v", err)
+	}
+
+	kv.Put("/khronos/jobs/parser", []byte("v1"), nil)
+	kv.Put("/khronos/jobsets/crawler", []byte("v1"), nil)
+	pairs, err := kv.List("/khronos/jobs/", nil)
+	if err != nil || len(pairs) != 2 || pairs[0].Key != "khronos/jobs/parser" {
+		t.Fatalf("expected the 2 jobs in order got %v %v", pairs, err)
+	}
+
+	kv.DeleteTree("/khronos/jobs")
+	if _, err := kv.List("/khronos/jobs", nil); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound got %v", err)
+	}
+	if ok, _ := kv.Exists("/khronos/jobsets/crawler", nil); !ok {
+		t.Fatalf("expected the sibling directory kept")
+	}
+}
+
+//go test -v -run=TestMemoryTTL
+func TestMemoryTTL(t *testing.T) {
+	kv, _ := NewMemoryKV(nil, nil)
+
+	kv.Put("/khronos/idempotency/spider/key", []byte("1"), &store.WriteOptions{TTL: 50 * time.Millisecond})
+	kv.Put("/khronos/idempotency/spider/renewed", []byte("1"), &store.WriteOptions{TTL: 50 * time.Millisecond})
+	kv.Put("/khronos/idempotency/spider/renewed", []byte("2"), nil)
+
+	if ok, _ := kv.Exists("/khronos/idempotency/spider/key", nil); !ok {
+		t.Fatalf("expected the key before its TTL")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := kv.Get("/khronos/idempotency/spider/key", nil); err != store.ErrKeyNotFound {
+		t.Fatalf("expected the key expired got %v", err)
+	}
+	if ok, _ := kv.Exists("/khronos/idempotency/spider/renewed", nil); !ok {
+		t.Fatalf("expected the key put again without TTL kept")
+	}
+}
+
+//go test -v -run=TestMemoryWatchTree
+func TestMemoryWatchTree(t *testing.T) {
+	kv, _ := NewMemoryKV(nil, nil)
+	kv.Put("/khronos/jobs/spider", []byte("v1"), nil)
+
+	stopCh := make(chan struct{})
+	events, err := kv.WatchTree("/khronos/jobs", stopCh, nil)
+	if err != nil {
+		t.Fatalf("error watching: %s", err)
+	}
+
+	next := func() []*store.KVPair {
+		select {
+		case pairs := <-events:
+			return pairs
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event")
+		}
+		return nil
+	}
+
+	if pairs := next(); len(pairs) != 1 {
+		t.Fatalf("expected the current job got %v", pairs)
+	}
+
+	kv.Put("/khronos/jobs/parser", []byte("v1"), nil)
+	if pairs := next(); len(pairs) != 2 {
+		t.Fatalf("expected 2 jobs got %v", pairs)
+	}
+
+	// the changes outside of the directory aren't sent
+	kv.Put("/khronos/executions/spider/1", []byte("v1"), nil)
+	kv.Delete("/khronos/jobs/spider")
+	if pairs := next(); len(pairs) != 1 || pairs[0].Key != "khronos/jobs/parser" {
+		t.Fatalf("expected the remaining job got %v", pairs)
+	}
+
+	close(stopCh)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatalf("expected the events closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the events closed")
+	}
+}
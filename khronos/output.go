@@ -0,0 +1,57 @@
+package khronos
+
+const (
+	// MaxOutputChunk limits the size of a chunk of output sent by AppendOutput.
+	MaxOutputChunk = 64 << 10
+
+	// MaxOutputSize limits the output stored for an execution, the output beyond it is dropped.
+	MaxOutputSize = 16 << 20
+
+	// MaxOutputRead limits the number of chunks read at once.
+	MaxOutputRead = 256
+
+	// TruncationMarker is the last chunk of an output which has reached MaxOutputSize.
+	TruncationMarker = "\n[khronos: output truncated]\n"
+)
+
+// OutputChunk is a piece of the output of a running execution.
+type OutputChunk struct {
+	JobName string
+
+	// Key of the execution, see Execution.Key
+	ExecutionKey string
+
+	// Sequence number of the chunk in the output, starting from 1, set by the store
+	Seq int
+
+	Data []byte
+}
+
+// OutputIndex tells the number of chunks and the size of the output of an execution.
+type OutputIndex struct {
+	Chunks int `json:"chunks"`
+
+	Size int `json:"size"`
+
+	// the output has reached MaxOutputSize, further chunks are dropped
+	Truncated bool `json:"truncated"`
+}
+
+// next returns the index after appending the data, with the part of the data kept.
+func (idx OutputIndex) next(data []byte) (OutputIndex, []byte) {
+	if idx.Truncated {
+		return idx, nil
+	}
+
+	n := idx
+	if n.Size+len(data) > MaxOutputSize {
+		data = data[:MaxOutputSize-n.Size]
+		// the kept part and the marker
+		n.Chunks += 2
+		n.Truncated = true
+	} else {
+		n.Chunks++
+	}
+	n.Size += len(data)
+	return n, data
+}
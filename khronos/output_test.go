@@ -0,0 +1,26 @@
+package khronos
+
+import (
+	"testing"
+)
+
+//go test -v -run=TestOutputIndexNext
+func TestOutputIndexNext(t *testing.T) {
+	var idx OutputIndex
+
+	idx, data := idx.next([]byte("hello"))
+	if idx.Chunks != 1 || idx.Size != 5 || idx.Truncated || string(data) != "hello" {
+		t.Fatalf("unexpected index %+v, %q", idx, data)
+	}
+
+	idx.Size = MaxOutputSize - 2
+	idx, data = idx.next([]byte("world"))
+	if idx.Chunks != 3 || idx.Size != MaxOutputSize || !idx.Truncated || string(data) != "wo" {
+		t.Fatalf("expected the chunk truncated with a marker got %+v, %q", idx, data)
+	}
+
+	next, data := idx.next([]byte("dropped"))
+	if next != idx || data != nil {
+		t.Fatalf("expected the chunk dropped got %+v, %q", next, data)
+	}
+}
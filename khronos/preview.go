@@ -0,0 +1,161 @@
+package khronos
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultHotspotThreshold is the number of fires of an application in the same second making a hotspot.
+	DefaultHotspotThreshold = 10
+
+	// MaxUpcomingFires limits the fires of an upcoming calendar.
+	MaxUpcomingFires = 100000
+)
+
+// Fire is a scheduled activation of a job.
+type Fire struct {
+	Job string `json:"job"`
+
+	At time.Time `json:"at"`
+}
+
+// Hotspot is a second in which many jobs of an application fire together.
+type Hotspot struct {
+	Application string `json:"application"`
+
+	At time.Time `json:"at"`
+
+	Jobs []string `json:"jobs"`
+}
+
+// Upcoming is the calendar of the scheduled fires of the jobs in a time window.
+type Upcoming struct {
+	From time.Time `json:"from"`
+
+	To time.Time `json:"to"`
+
+	// fires in time order grouped by application
+	Applications map[string][]Fire `json:"applications"`
+
+	// the busiest seconds first
+	Hotspots []Hotspot `json:"hotspots"`
+
+	// the fires beyond MaxUpcomingFires are left out
+	Truncated bool `json:"truncated"`
+}
+
+// PlanUpcoming lists the fires of the jobs from (exclusive) until to (inclusive), leaving out the disabled
+// and done jobs and the fires in a blackout of the calendars. A second in which at least threshold
+// jobs of an application fire is a hotspot.
+func PlanUpcoming(jobs []*Job, calendars []*Calendar, from, to time.Time, threshold int) *Upcoming {
+	if threshold <= 0 {
+		threshold = DefaultHotspotThreshold
+	}
+
+	up := &Upcoming{
+		From:         from,
+		To:           to,
+		Applications: make(map[string][]Fire),
+		Hotspots:     make([]Hotspot, 0),
+	}
+
+	total := 0
+	for _, j := range jobs {
+		if j.Disabled || j.IsDone {
+			continue
+		}
+
+		runs, truncated := upcomingRuns(j, calendars, from, to, MaxUpcomingFires-total)
+		up.Truncated = up.Truncated || truncated
+		for _, at := range runs {
+			up.Applications[j.Application] = append(up.Applications[j.Application], Fire{Job: j.Name, At: at})
+		}
+		total += len(runs)
+	}
+
+	for app, fires := range up.Applications {
+		sort.SliceStable(fires, func(i, k int) bool { return fires[i].At.Before(fires[k].At) })
+
+		for i := 0; i < len(fires); {
+			second := fires[i].At.Truncate(time.Second)
+			k := i
+			names := make([]string, 0)
+			for ; k < len(fires) && fires[k].At.Truncate(time.Second).Equal(second); k++ {
+				names = append(names, fires[k].Job)
+			}
+			if len(names) >= threshold {
+				up.Hotspots = append(up.Hotspots, Hotspot{Application: app, At: second, Jobs: names})
+			}
+			i = k
+		}
+	}
+
+	sort.SliceStable(up.Hotspots, func(i, k int) bool {
+		if len(up.Hotspots[i].Jobs) != len(up.Hotspots[k].Jobs) {
+			return len(up.Hotspots[i].Jobs) > len(up.Hotspots[k].Jobs)
+		}
+		return up.Hotspots[i].At.Before(up.Hotspots[k].At)
+	})
+
+	return up
+}
+
+// upcomingRuns returns at most n fires of the job from (exclusive) until to (inclusive, unbounded if zero)
+// out of the blackouts of the calendars, it reports whether there are more of them.
+// The fires of a spread job are delayed by its offset as they're run, its random jitter isn't shown.
+func upcomingRuns(j *Job, calendars []*Calendar, from, to time.Time, n int) (runs []time.Time, more bool) {
+	runs = make([]time.Time, 0)
+
+	sched, err := j.CronSchedule()
+	if err != nil {
+		return runs, false
+	}
+	// the runs are in time order unless the slots of the schedule differ in length
+	defer func() { sort.Slice(runs, func(i, k int) bool { return runs[i].Before(runs[k]) }) }()
+
+	// a spread fire scheduled up to a slot before from may run after it
+	start := from
+	if j.Spread {
+		if next := sched.Next(from); !next.IsZero() {
+			if after := sched.Next(next); !after.IsZero() {
+				start = from.Add(-after.Sub(next))
+			}
+		}
+	}
+
+	applied := make([]*Calendar, 0)
+	for _, c := range calendars {
+		if c.AppliesTo(j) {
+			applied = append(applied, c)
+		}
+	}
+
+	// the blacked out fires count towards the limit too, so a calendar can't keep us busy forever
+	for t, seen := sched.Next(start), 0; !t.IsZero(); t, seen = sched.Next(t), seen+1 {
+		if !to.IsZero() && t.After(to) {
+			return runs, false
+		}
+		if len(runs) >= n || seen >= MaxUpcomingFires {
+			return runs, true
+		}
+		// the blackouts are checked as the run starts, see Job.fire
+		at := t.Add(j.spreadDelay(sched, t))
+		if !at.After(from) || (!to.IsZero() && at.After(to)) {
+			continue
+		}
+		if !inBlackout(applied, at) {
+			runs = append(runs, at)
+		}
+	}
+	return runs, false
+}
+
+func inBlackout(calendars []*Calendar, t time.Time) bool {
+	for _, c := range calendars {
+		if c.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
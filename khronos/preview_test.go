@@ -0,0 +1,102 @@
+package khronos
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestPlanUpcoming
+func TestPlanUpcoming(t *testing.T) {
+	jobs := make([]*Job, 0)
+	for i := 0; i < 12; i++ {
+		jobs = append(jobs, &Job{Name: fmt.Sprintf("spider-%d", i), Schedule: "@hourly", Application: "spider"})
+	}
+	jobs = append(jobs,
+		&Job{Name: "report", Schedule: "0 30 * * * *", Application: "report", Calendars: []string{"maintenance"}},
+		&Job{Name: "disabled", Schedule: "@hourly", Application: "report", Disabled: true},
+	)
+	calendars := []*Calendar{{
+		Name:    "maintenance",
+		Windows: []CalendarWindow{{Schedule: "0 0 2 * * *", Duration: "1h"}},
+	}}
+
+	from := time.Date(2018, 6, 8, 0, 0, 0, 0, time.Local)
+	up := PlanUpcoming(jobs, calendars, from, from.Add(4*time.Hour), 0)
+
+	if len(up.Applications["spider"]) != 12*4 {
+		t.Fatalf("expected %d fires of spider got %d", 12*4, len(up.Applications["spider"]))
+	}
+	// the fire at 02:30 is blacked out
+	if len(up.Applications["report"]) != 3 {
+		t.Fatalf("expected 3 fires of report got %v", up.Applications["report"])
+	}
+	for _, f := range up.Applications["report"] {
+		if f.At.Hour() == 2 || f.Job != "report" {
+			t.Fatalf("unexpected fire %v", f)
+		}
+	}
+
+	if len(up.Hotspots) != 4 {
+		t.Fatalf("expected 4 hotspots got %v", up.Hotspots)
+	}
+	if h := up.Hotspots[0]; h.Application != "spider" || len(h.Jobs) != 12 || !h.At.Equal(from.Add(time.Hour)) {
+		t.Fatalf("unexpected hotspot %v", h)
+	}
+
+	if up := PlanUpcoming(jobs, calendars, from, from.Add(4*time.Hour), 13); len(up.Hotspots) != 0 {
+		t.Fatalf("expected no hotspot got %v", up.Hotspots)
+	}
+}
+
+//go test -v -run=TestUpcomingRunsTruncated
+func TestUpcomingRunsTruncated(t *testing.T) {
+	j := &Job{Name: "fast", Schedule: "@every 1s"}
+	from := time.Date(2018, 6, 8, 0, 0, 0, 0, time.Local)
+
+	runs, truncated := upcomingRuns(j, nil, from, time.Time{}, 5)
+	if len(runs) != 5 || !truncated {
+		t.Fatalf("expected 5 runs truncated got %d, %t", len(runs), truncated)
+	}
+
+	runs, truncated = upcomingRuns(j, nil, from, from.Add(3*time.Second), 5)
+	if len(runs) != 3 || truncated {
+		t.Fatalf("expected 3 runs got %d, %t", len(runs), truncated)
+	}
+}
+
+//go test -v -run=TestPlanUpcomingSpread
+func TestPlanUpcomingSpread(t *testing.T) {
+	jobs := make([]*Job, 0)
+	for i := 0; i < 12; i++ {
+		jobs = append(jobs, &Job{Name: fmt.Sprintf("spider-%d", i), Schedule: "@hourly", Application: "spider", Spread: true})
+	}
+	from := time.Date(2018, 6, 8, 0, 0, 0, 0, time.Local)
+
+	// the spread jobs don't land on the same second
+	up := PlanUpcoming(jobs, nil, from, from.Add(4*time.Hour), 0)
+	if len(up.Hotspots) != 0 {
+		t.Fatalf("expected no hotspot of the spread jobs got %v", up.Hotspots)
+	}
+	for _, f := range up.Applications["spider"] {
+		if f.At.Truncate(time.Hour).Equal(f.At) {
+			t.Fatalf("expected the fire of %s delayed got %s", f.Job, f.At)
+		}
+	}
+
+	// the blackout applies at the delayed time, as the run is started
+	j := jobs[0]
+	j.Calendars = []string{"freeze"}
+	scheduled := from.Add(2 * time.Hour)
+	at := scheduled.Add(j.delay(scheduled))
+	calendars := []*Calendar{{Name: "freeze", Events: []CalendarEvent{{Start: at.Add(-time.Second), End: at.Add(time.Second)}}}}
+	runs, _ := upcomingRuns(j, calendars, from, from.Add(4*time.Hour), 10)
+	if len(runs) != 3 {
+		t.Fatalf("expected 3 runs got %v", runs)
+	}
+	for _, r := range runs {
+		if r.Equal(at) {
+			t.Fatalf("expected the run at %s blacked out", at)
+		}
+	}
+}
@@ -8,6 +8,9 @@ type Processor struct {
 	Status            bool
 	MaxExecutionLimit int
 	Undone            int
+	// Labels of this processor used by job node selectors.
+	// e.g. {"region": "hk", "gpu": "false", "tier": "a"}
+	Labels map[string]string
 }
 
 const MaxExecutionLimit = 10
@@ -0,0 +1,175 @@
+package khronos
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StallCheckInterval is how often the running executions are checked for missing heartbeats.
+const StallCheckInterval = 10 * time.Second
+
+// ExecutionProgress is a progress report of a running execution, it's a heartbeat of the execution too.
+type ExecutionProgress struct {
+	JobName string
+
+	// Key of the execution, see Execution.Key
+	ExecutionKey string
+
+	// from 0 to 100
+	Percent float64
+
+	Message string
+
+	// replace the counters of the execution, e.g. {"pages": 120}
+	Counters map[string]int64
+}
+
+// apply records the progress on a running execution, it reports false if the execution has finished.
+func (p *ExecutionProgress) apply(ex *Execution, now time.Time) bool {
+	if !ex.FinishedAt.IsZero() {
+		return false
+	}
+
+	ex.Progress = p.Percent
+	ex.Message = p.Message
+	if p.Counters != nil {
+		ex.Counters = p.Counters
+	}
+	ex.HeartbeatAt = now
+	return true
+}
+
+// isStalled reports whether a running execution has missed its heartbeats longer than the timeout,
+// an execution without any progress report yet is measured from its start.
+func isStalled(ex *Execution, timeout time.Duration, now time.Time) bool {
+	if timeout <= 0 || !ex.FinishedAt.IsZero() {
+		return false
+	}
+
+	last := ex.HeartbeatAt
+	if last.IsZero() {
+		last = ex.StartedAt
+	}
+	return now.Sub(last) > timeout
+}
+
+// DetectStalls finishes the stalled executions as failed, independently of the Ping of the nodes.
+func (a *Agent) DetectStalls() {
+	for {
+		time.Sleep(StallCheckInterval)
+		a.checkStalls(time.Now())
+	}
+}
+
+func (a *Agent) checkStalls(now time.Time) {
+	jobs, err := a.store.GetJobs()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("agent.checkStalls GetJobs fail.")
+		return
+	}
+
+	timeouts := make(map[string]time.Duration)
+	for _, j := range jobs {
+		if j.StallTimeout > 0 {
+			timeouts[j.Name] = time.Duration(j.StallTimeout) * time.Second
+		}
+	}
+	if len(timeouts) == 0 {
+		return
+	}
+
+	exs, err := a.store.GetRunningExecutions("")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("agent.checkStalls query running executions fail.")
+		return
+	}
+
+	for _, ex := range exs {
+		timeout, ok := timeouts[ex.JobName]
+		if !ok || !isStalled(ex, timeout, now) {
+			continue
+		}
+
+		stalled := false
+		updated, err := a.store.UpdateExecution(ex.JobName, ex.Key(), func(e *Execution) bool {
+			// it may have reported or finished meanwhile
+			if !isStalled(e, timeout, now) {
+				return false
+			}
+			e.Stalled = true
+			e.FinishedAt = now
+			e.Success = false
+			e.Output = []byte(fmt.Sprintf("stalled: no heartbeat for %s", timeout))
+			stalled = true
+			return true
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job": ex.JobName,
+				"err": err,
+			}).Error("agent.checkStalls UpdateExecution fail.")
+			continue
+		}
+		if !stalled {
+			continue
+		}
+		ex = updated
+
+		log.WithFields(log.Fields{
+			"job":       ex.JobName,
+			"execution": ex.Key(),
+			"node":      ex.NodeName,
+		}).Error("agent.checkStalls execution stalled.")
+
+		a.cancelOnWorker(ex)
+		go ex.DecCounter(ex.NodeName, "undo")
+		go ex.DecCounter(ex.NodeName, ex.Tags["type"])
+
+		a.recordStalled(ex)
+		a.releaseSlot(ex)
+	}
+}
+
+// countFailure counts an execution finished by the agent rather than by its worker, e.g. stalled or cancelled,
+// as an error of its job.
+func (a *Agent) countFailure(ex *Execution) bool {
+	_, err := a.store.UpdateJobMetadata(ex.JobName, func(m *JobMetaData) bool {
+		m.ErrorCount += 1
+		m.LastError = ex.FinishedAt
+		return true
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": ex.JobName,
+			"err": err,
+		}).Error("agent.countFailure UpdateJobMetadata fail.")
+		return false
+	}
+	return true
+}
+
+// recordStalled counts the stalled execution as an error of its job.
+func (a *Agent) recordStalled(ex *Execution) {
+	if !a.countFailure(ex) {
+		return
+	}
+
+	job, err := a.store.GetJob(ex.JobName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": ex.JobName,
+			"err": err,
+		}).Error("agent.recordStalled GetJob fail.")
+		return
+	}
+
+	if job.Concurrency == ConcurrencyQueue {
+		go a.RunQueued(job.Name)
+	}
+}
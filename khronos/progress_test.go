@@ -0,0 +1,42 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestIsStalled
+func TestIsStalled(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+	timeout := time.Minute
+
+	ex := &Execution{StartedAt: now.Add(-2 * time.Minute)}
+	if !isStalled(ex, timeout, now) {
+		t.Fatalf("expected an execution without heartbeat since its start stalled")
+	}
+
+	p := &ExecutionProgress{Percent: 40, Message: "crawling", Counters: map[string]int64{"pages": 120}}
+	if !p.apply(ex, now.Add(-30*time.Second)) {
+		t.Fatalf("expected the progress applied to a running execution")
+	}
+	if ex.Progress != 40 || ex.Message != "crawling" || ex.Counters["pages"] != 120 {
+		t.Fatalf("unexpected progress %+v", ex)
+	}
+	if isStalled(ex, timeout, now) {
+		t.Fatalf("expected an execution with a recent heartbeat running")
+	}
+	if !isStalled(ex, timeout, now.Add(time.Minute)) {
+		t.Fatalf("expected an execution stalled after the timeout of its heartbeat")
+	}
+	if isStalled(ex, 0, now.Add(time.Hour)) {
+		t.Fatalf("expected no stall without timeout")
+	}
+
+	ex.FinishedAt = now
+	if isStalled(ex, timeout, now.Add(time.Hour)) {
+		t.Fatalf("expected a finished execution not stalled")
+	}
+	if p.apply(ex, now) {
+		t.Fatalf("expected the progress of a finished execution refused")
+	}
+}
@@ -0,0 +1,126 @@
+package khronos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageSize is the number of executions of a page by default.
+	DefaultPageSize = 50
+
+	// MaxPageSize limits the number of executions of a page.
+	MaxPageSize = 1000
+)
+
+// ExecutionQuery filters the executions, the latest first.
+type ExecutionQuery struct {
+	Job string `json:"job"`
+
+	Node string `json:"node"`
+
+	// one of the ExecutionStates
+	Status string `json:"status"`
+
+	// requires Job
+	Group int64 `json:"group"`
+
+	// the executions started from From (inclusive) until To (exclusive), zero means no limit
+	From time.Time `json:"from"`
+
+	To time.Time `json:"to"`
+
+	// the cursor of the previous page, empty for the first page
+	Cursor string `json:"cursor"`
+
+	// default to DefaultPageSize, at most MaxPageSize
+	Limit int `json:"limit"`
+}
+
+// ExecutionPage is a page of the executions of a query.
+type ExecutionPage struct {
+	Executions []*Execution `json:"executions"`
+
+	// the cursor of the next page, empty on the last page
+	Cursor string `json:"cursor"`
+}
+
+// Validate checks the filters of the query.
+func (q *ExecutionQuery) Validate() error {
+	if q.Status != "" && !StringInSlice(q.Status, ExecutionStates) {
+		return fmt.Errorf("query: unknown status '%s', expected one of %s", q.Status, strings.Join(ExecutionStates, ", "))
+	}
+	if q.Group != 0 && q.Job == "" {
+		return fmt.Errorf("query: the group requires the job")
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("query: the limit must not be negative")
+	}
+	return nil
+}
+
+// Matches reports whether the execution passes the filters of the query.
+func (q *ExecutionQuery) Matches(ex *Execution) bool {
+	if q.Job != "" && ex.JobName != q.Job {
+		return false
+	}
+	if q.Node != "" && ex.NodeName != q.Node {
+		return false
+	}
+	if q.Status != "" && ex.State() != q.Status {
+		return false
+	}
+	if q.Group != 0 && ex.Group != q.Group {
+		return false
+	}
+	return q.inRange(ex.StartedAt)
+}
+
+func (q *ExecutionQuery) inRange(t time.Time) bool {
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !t.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+func (q *ExecutionQuery) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// executionRef locates an execution found in a listing or an index.
+type executionRef struct {
+	job string
+	key string
+
+	// zero padded group of a group index
+	group string
+}
+
+// cursor orders the references by time then job, it's the cursor of a page ending with the reference.
+func (r executionRef) cursor() string {
+	return r.key + "/" + r.job
+}
+
+// startedAt returns the start time of the execution from its key, see Execution.Key.
+func (r executionRef) startedAt() (time.Time, bool) {
+	idx := strings.Index(r.key, "-")
+	if idx <= 0 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(r.key[:idx], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
@@ -0,0 +1,122 @@
+package khronos
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestExecutionState
+func TestExecutionState(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		ex    *Execution
+		state string
+	}{
+		{&Execution{StartedAt: now}, StateRunning},
+		{&Execution{StartedAt: now, FinishedAt: now.Add(time.Second), Success: true}, StateSuccess},
+		{&Execution{StartedAt: now, FinishedAt: now.Add(time.Second)}, StateFailed},
+		{&Execution{StartedAt: now, FinishedAt: now, Missed: true}, StateMissed},
+		{&Execution{StartedAt: now, FinishedAt: now, Skipped: true}, StateSkipped},
+	}
+	for _, c := range cases {
+		if state := c.ex.State(); state != c.state {
+			t.Fatalf("expected state %s got %s", c.state, state)
+		}
+	}
+}
+
+//go test -v -run=TestQueryMatches
+func TestQueryMatches(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+	ex := &Execution{
+		JobName:    "spider",
+		NodeName:   "node1",
+		Group:      now.UnixNano(),
+		StartedAt:  now,
+		FinishedAt: now.Add(time.Minute),
+		Success:    true,
+	}
+
+	matching := []ExecutionQuery{
+		{},
+		{Job: "spider", Node: "node1", Status: StateSuccess},
+		{Job: "spider", Group: now.UnixNano()},
+		{From: now, To: now.Add(time.Hour)},
+	}
+	for _, q := range matching {
+		if !q.Matches(ex) {
+			t.Fatalf("expected %+v matching", q)
+		}
+	}
+
+	missing := []ExecutionQuery{
+		{Job: "parser"},
+		{Node: "node2"},
+		{Status: StateFailed},
+		{Job: "spider", Group: 1},
+		{From: now.Add(time.Second)},
+		{To: now},
+	}
+	for _, q := range missing {
+		if q.Matches(ex) {
+			t.Fatalf("expected %+v not matching", q)
+		}
+	}
+}
+
+//go test -v -run=TestQueryValidate
+func TestQueryValidate(t *testing.T) {
+	invalid := []ExecutionQuery{
+		{Status: "done"},
+		{Group: 1},
+		{Limit: -1},
+	}
+	for _, q := range invalid {
+		if err := q.Validate(); err == nil {
+			t.Fatalf("expected %+v invalid", q)
+		}
+	}
+
+	q := ExecutionQuery{Job: "spider", Group: 1, Status: StateRunning}
+	if err := q.Validate(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if q.limit() != DefaultPageSize {
+		t.Fatalf("expected the default page size got %d", q.limit())
+	}
+	q.Limit = MaxPageSize + 1
+	if q.limit() != MaxPageSize {
+		t.Fatalf("expected the max page size got %d", q.limit())
+	}
+}
+
+//go test -v -run=TestQueryCursor
+func TestQueryCursor(t *testing.T) {
+	// before and after the number of digits of the nanoseconds changes
+	times := []time.Time{
+		time.Unix(0, 999999999),
+		time.Unix(0, 1000000000),
+		time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local),
+	}
+
+	refs := make([]executionRef, 0)
+	for _, started := range times {
+		ex := &Execution{JobName: "spider", NodeName: "node1", StartedAt: started}
+		ref := executionRef{job: ex.JobName, key: ex.Key()}
+		if at, ok := ref.startedAt(); !ok || !at.Equal(started) {
+			t.Fatalf("expected the start time %s got %s", started, at)
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(i, k int) bool { return refs[i].cursor() > refs[k].cursor() })
+	for i := 1; i < len(refs); i++ {
+		prev, _ := refs[i-1].startedAt()
+		at, _ := refs[i].startedAt()
+		if at.After(prev) {
+			t.Fatalf("expected the cursors ordered by time, %s after %s", at, prev)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package khronos
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultJanitorInterval is how often the janitor prunes the executions by default.
+const DefaultJanitorInterval = time.Minute
+
+// Retention tells which finished executions of a job are kept, the running executions are always kept.
+// The unset limits of a job are nil, or empty for max age, and are taken from the defaults.
+type Retention struct {
+	// Keep the latest executions up to this number, 0 means no limit.
+	MaxExecutions *int `json:"max_executions,omitempty"`
+
+	// Keep the executions started within this duration, e.g. "720h". "0" means no limit.
+	MaxAge string `json:"max_age"`
+
+	// Keep the latest failed executions up to this number whatever the other limits.
+	KeepFailures *int `json:"keep_failures,omitempty"`
+}
+
+// NewRetention returns a retention with all of its limits set, e.g. the defaults of the configuration.
+func NewRetention(maxExecutions int, maxAge string, keepFailures int) Retention {
+	return Retention{MaxExecutions: &maxExecutions, MaxAge: maxAge, KeepFailures: &keepFailures}
+}
+
+// Merge returns the retention with the unset fields taken from the defaults.
+func (r *Retention) Merge(defaults Retention) Retention {
+	if r == nil {
+		return defaults
+	}
+
+	merged := *r
+	if merged.MaxExecutions == nil {
+		merged.MaxExecutions = defaults.MaxExecutions
+	}
+	if merged.MaxAge == "" {
+		merged.MaxAge = defaults.MaxAge
+	}
+	if merged.KeepFailures == nil {
+		merged.KeepFailures = defaults.KeepFailures
+	}
+	return merged
+}
+
+// limit returns the value of a limit, 0 if it's unset.
+func limit(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// Validate checks the limits of the retention.
+func (r *Retention) Validate() error {
+	e := &ValidationError{}
+	if limit(r.MaxExecutions) < 0 {
+		e.add("retention.max_executions", "must not be negative")
+	}
+	if r.MaxAge != "" {
+		if d, err := time.ParseDuration(r.MaxAge); err != nil || d < 0 {
+			e.add("retention.max_age", "invalid duration '%s', e.g. 720h", r.MaxAge)
+		}
+	}
+	if limit(r.KeepFailures) < 0 {
+		e.add("retention.keep_failures", "must not be negative")
+	}
+
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
+
+// pruneExecutions returns the executions to delete by the retention.
+func pruneExecutions(exs []*Execution, r Retention, now time.Time) []*Execution {
+	finished := make([]*Execution, 0, len(exs))
+	for _, ex := range exs {
+		if !ex.FinishedAt.IsZero() {
+			finished = append(finished, ex)
+		}
+	}
+	// the latest first
+	sort.SliceStable(finished, func(i, k int) bool { return finished[i].StartedAt.After(finished[k].StartedAt) })
+
+	var maxAge time.Duration
+	if r.MaxAge != "" {
+		maxAge, _ = time.ParseDuration(r.MaxAge)
+	}
+
+	pruned := make([]*Execution, 0)
+	failures := 0
+	for i, ex := range finished {
+		if ex.Ran() && !ex.Success {
+			failures++
+			if failures <= limit(r.KeepFailures) {
+				continue
+			}
+		}
+
+		if max := limit(r.MaxExecutions); max > 0 && i >= max {
+			pruned = append(pruned, ex)
+			continue
+		}
+		if maxAge > 0 && now.Sub(ex.StartedAt) > maxAge {
+			pruned = append(pruned, ex)
+		}
+	}
+	return pruned
+}
+
+// Janitor prunes the executions of the jobs by their retention in the background.
+func (a *Agent) Janitor() {
+	interval := a.config.JanitorInterval
+	if interval <= 0 {
+		interval = DefaultJanitorInterval
+	}
+
+	for {
+		time.Sleep(interval)
+		a.prune(time.Now())
+	}
+}
+
+func (a *Agent) prune(now time.Time) {
+	jobs, err := a.store.GetJobs()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("agent.prune GetJobs fail.")
+		return
+	}
+
+	for _, j := range jobs {
+		exs, err := queryAll(a.store, ExecutionQuery{Job: j.Name})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job": j.Name,
+				"err": err,
+			}).Error("agent.prune QueryExecutions fail.")
+			continue
+		}
+
+		pruned := pruneExecutions(exs, j.Retention.Merge(a.config.Retention), now)
+		for _, ex := range pruned {
+			if err := a.store.DeleteExecution(ex); err != nil {
+				log.WithFields(log.Fields{
+					"job":       j.Name,
+					"execution": ex.Key(),
+					"err":       err,
+				}).Error("agent.prune DeleteExecution fail.")
+			}
+		}
+
+		if len(pruned) > 0 {
+			log.WithFields(log.Fields{
+				"job":    j.Name,
+				"pruned": len(pruned),
+			}).Debug("agent.prune pruned executions.")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package khronos
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestPruneExecutions
+func TestPruneExecutions(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+
+	// one execution an hour, the latest first, failed every third one
+	exs := make([]*Execution, 0)
+	for i := 0; i < 10; i++ {
+		started := now.Add(-time.Duration(i) * time.Hour)
+		exs = append(exs, &Execution{
+			JobName:    "spider",
+			StartedAt:  started,
+			FinishedAt: started.Add(time.Minute),
+			Success:    i%3 != 0,
+		})
+	}
+	running := &Execution{JobName: "spider", StartedAt: now.Add(-20 * time.Hour)}
+	exs = append(exs, running)
+
+	pruned := pruneExecutions(exs, NewRetention(4, "", 0), now)
+	if len(pruned) != 6 {
+		t.Fatalf("expected 6 executions pruned by count got %d", len(pruned))
+	}
+	for _, ex := range pruned {
+		if ex == running || !ex.StartedAt.Before(now.Add(-3*time.Hour)) {
+			t.Fatalf("unexpected pruned execution started at %s", ex.StartedAt)
+		}
+	}
+
+	// failed at 0, 3, 6 and 9 hours ago, the latest 3 are kept
+	pruned = pruneExecutions(exs, NewRetention(4, "", 3), now)
+	if len(pruned) != 5 {
+		t.Fatalf("expected 5 executions pruned got %d", len(pruned))
+	}
+	for _, ex := range pruned {
+		if ex.StartedAt.Equal(now.Add(-6 * time.Hour)) {
+			t.Fatalf("expected the failure of 6 hours ago kept")
+		}
+	}
+
+	pruned = pruneExecutions(exs, Retention{MaxAge: "150m"}, now)
+	if len(pruned) != 7 {
+		t.Fatalf("expected 7 executions pruned by age got %d", len(pruned))
+	}
+
+	if pruned := pruneExecutions(exs, Retention{}, now); len(pruned) != 0 {
+		t.Fatalf("expected nothing pruned without limits got %d", len(pruned))
+	}
+}
+
+//go test -v -run=TestRetentionMerge
+func TestRetentionMerge(t *testing.T) {
+	defaults := NewRetention(MaxExecutions, "720h", 20)
+
+	var none *Retention
+	if merged := none.Merge(defaults); merged != defaults {
+		t.Fatalf("expected the defaults got %+v", merged)
+	}
+
+	ten := 10
+	r := &Retention{MaxExecutions: &ten}
+	merged := r.Merge(defaults)
+	if limit(merged.MaxExecutions) != 10 || merged.MaxAge != "720h" || limit(merged.KeepFailures) != 20 {
+		t.Fatalf("unexpected merged retention %+v", merged)
+	}
+
+	// 0 is no limit rather than unset
+	var r2 Retention
+	if err := json.Unmarshal([]byte(`{"max_executions": 0, "max_age": "0", "keep_failures": 0}`), &r2); err != nil {
+		t.Fatal(err)
+	}
+	merged = r2.Merge(defaults)
+	if limit(merged.MaxExecutions) != 0 || limit(merged.KeepFailures) != 0 || merged.MaxAge != "0" {
+		t.Fatalf("expected no limits got %+v", merged)
+	}
+	if err := r2.Validate(); err != nil {
+		t.Fatalf("expected the no limits valid got %s", err)
+	}
+	now := time.Now()
+	exs := make([]*Execution, 0)
+	for i := 0; i < 300; i++ {
+		started := now.Add(-time.Duration(i) * 24 * time.Hour)
+		exs = append(exs, &Execution{JobName: "spider", StartedAt: started, FinishedAt: started.Add(time.Minute)})
+	}
+	if pruned := pruneExecutions(exs, merged, now); len(pruned) != 0 {
+		t.Fatalf("expected nothing pruned without limits got %d", len(pruned))
+	}
+
+	if err := (&Retention{MaxAge: "a month"}).Validate(); err == nil {
+		t.Fatalf("expected an error of an invalid max age")
+	}
+}
+
+//go test -v -run=TestAgentPrune
+func TestAgentPrune(t *testing.T) {
+	s := createTestStore()
+	a := createTestAgent(s)
+	a.config.Retention = NewRetention(MaxExecutions, "", 0)
+
+	two := 2
+	if err := s.SetJob(&Job{Name: "spider", Schedule: "@every 5s", Retention: &Retention{MaxExecutions: &two}}); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		started := now.Add(-time.Duration(i) * time.Hour)
+		ex := &Execution{JobName: "spider", NodeName: "node1", Group: started.UnixNano(), StartedAt: started, FinishedAt: started.Add(time.Minute), Success: true}
+		if _, err := s.SetExecution(ex); err != nil {
+			t.Fatalf("error setting execution: %s", err)
+		}
+	}
+
+	a.prune(now)
+	exs, err := s.GetExecutions("spider")
+	if err != nil {
+		t.Fatalf("error getting executions: %s", err)
+	}
+	if len(exs) != 2 {
+		t.Fatalf("expected 2 executions kept got %d", len(exs))
+	}
+}
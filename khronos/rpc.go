@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
+	"github.com/abronan/valkeyrie/store"
 	log "github.com/sirupsen/logrus"
 	"github.com/smallnest/rpcx/client"
 	"github.com/smallnest/rpcx/server"
@@ -40,8 +42,16 @@ func listenRPC(a *Agent) {
 
 // it means that the client is down when servers accept a ServNodeReg request.
 func (r *RPCServer) ServNodeReg(ctx context.Context, args *Processor, reply *RPCReply) error {
+	// the shards have been lost with the restart of the node
+	shards, err := r.agent.store.GetUnfinishedShards(args.NodeName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("get unfinished shards before ServNodeReg.SetProcessor")
+	}
+
 	// need to remove unfinished executions
-	err := r.agent.store.DeleteExecutionsByNodeName(args.NodeName)
+	err = r.agent.store.DeleteExecutionsByNodeName(args.NodeName)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
@@ -63,15 +73,55 @@ func (r *RPCServer) ServNodeReg(ctx context.Context, args *Processor, reply *RPC
 		reply.Success = true
 	}
 
+	go r.agent.ReassignShards(shards)
+
 	return err
 }
 
-func (r *RPCServer) MakeJob(ctx context.Context, args *Job, reply *RPCReply) error {
+// JobReply is the reply of MakeJob and ValidateJob.
+type JobReply struct {
+	Success bool
+	Ack     int
+
+	// the schedule of the job in plain English
+	Description string
+
+	// the next fire times of the job
+	NextRuns []time.Time
+
+	// the invalid fields of the job
+	Errors []FieldError
+}
+
+// NextRunsCount is the number of fire times in the reply of MakeJob and ValidateJob.
+const NextRunsCount = 5
+
+// MakeJob validates and stores a job, an invalid job isn't stored
+// and its invalid fields are replied in Errors.
+func (r *RPCServer) MakeJob(ctx context.Context, args *Job, reply *JobReply) error {
+	if !checkJob(args, reply) {
+		log.WithFields(log.Fields{
+			"job":    args.Name,
+			"errors": reply.Errors,
+		}).Error("RPCServer: MakeJob invalid job.")
+		return nil
+	}
+
+	// the source of a job is set by PlanApply only, and a managed job is changed by its source only
+	if args.ManagedBy != "" {
+		reply.Errors = []FieldError{{Field: "managed_by", Message: "is set by the source applying the job files only"}}
+		return nil
+	}
+	if ej, err := r.agent.store.GetJob(args.Name); err == nil && ej.ManagedBy != "" {
+		reply.Errors = []FieldError{{Field: "managed_by", Message: fmt.Sprintf("the job is managed by '%s'", ej.ManagedBy)}}
+		return nil
+	}
 
 	err := r.agent.store.SetJob(args)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"job": args,
+			"job": args.Name,
+			"err": err,
 		}).Error("RPCServer: MakeJob failed.")
 	} else {
 		reply.Ack = reply.Ack + 1
@@ -81,6 +131,336 @@ func (r *RPCServer) MakeJob(ctx context.Context, args *Job, reply *RPCReply) err
 	return err
 }
 
+// ValidateJob validates a job as MakeJob does without storing it.
+func (r *RPCServer) ValidateJob(ctx context.Context, args *Job, reply *JobReply) error {
+	reply.Success = checkJob(args, reply)
+	return nil
+}
+
+// checkJob validates the job and fills the reply with its description and next fire times
+// or with its invalid fields.
+func checkJob(j *Job, reply *JobReply) bool {
+	if err := j.Validate(); err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			reply.Errors = verr.Errors
+		} else {
+			reply.Errors = []FieldError{{Field: "job", Message: err.Error()}}
+		}
+		return false
+	}
+
+	reply.Description, _ = j.Describe()
+	// with the spread offset, the blackouts are left to the NextRuns RPC
+	reply.NextRuns, _ = upcomingRuns(j, nil, time.Now(), time.Time{}, NextRunsCount)
+	return true
+}
+
+// InstantiateArgs creates or updates a job from a template.
+type InstantiateArgs struct {
+	Template string
+
+	// default to the name of the template job with its placeholders replaced
+	Name string
+
+	Params map[string]string
+}
+
+// TemplateInstances is the reply of GetTemplateInstances.
+type TemplateInstances struct {
+	Jobs []*Job
+}
+
+// SetTemplate stores a template and updates its instances.
+func (r *RPCServer) SetTemplate(ctx context.Context, args *Template, reply *RPCReply) error {
+	if err := args.Validate(); err != nil {
+		return err
+	}
+	// the instances aren't managed, see MakeJob
+	if args.Job.ManagedBy != "" {
+		return fmt.Errorf("template: the job can't be managed by '%s'", args.Job.ManagedBy)
+	}
+
+	err := r.agent.store.SetTemplate(args)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"template": args.Name,
+			"err":      err,
+		}).Error("RPCServer: SetTemplate failed.")
+		return err
+	}
+
+	updated := r.agent.UpdateTemplateInstances(args)
+	log.WithFields(log.Fields{
+		"template": args.Name,
+		"updated":  updated,
+	}).Debug("RPCServer: SetTemplate updated the instances.")
+
+	reply.Ack = reply.Ack + 1
+	reply.Success = true
+	return nil
+}
+
+// Instantiate validates and stores a job made of a template and parameters as MakeJob does.
+func (r *RPCServer) Instantiate(ctx context.Context, args *InstantiateArgs, reply *JobReply) error {
+	t, err := r.agent.store.GetTemplate(args.Template)
+	if err != nil {
+		return err
+	}
+
+	job, err := t.Instantiate(args.Name, args.Params)
+	if err != nil {
+		reply.Errors = []FieldError{{Field: "template_params", Message: err.Error()}}
+		return nil
+	}
+
+	return r.MakeJob(ctx, job, reply)
+}
+
+// GetTemplateInstances replies the jobs instantiated from a template.
+func (r *RPCServer) GetTemplateInstances(ctx context.Context, args *Template, reply *TemplateInstances) error {
+	jobs, err := r.agent.store.GetTemplateInstances(args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Jobs = jobs
+	return nil
+}
+
+// DeleteTemplate deletes a template without instances.
+func (r *RPCServer) DeleteTemplate(ctx context.Context, args *Template, reply *RPCReply) error {
+	instances, err := r.agent.store.GetTemplateInstances(args.Name)
+	if err != nil {
+		return err
+	}
+	if len(instances) > 0 {
+		return fmt.Errorf("template: '%s' still has %d instances", args.Name, len(instances))
+	}
+
+	if _, err := r.agent.store.DeleteTemplate(args.Name); err != nil {
+		return err
+	}
+	reply.Ack = reply.Ack + 1
+	reply.Success = true
+	return nil
+}
+
+// NextRunsArgs asks for the next fire times of a job.
+type NextRunsArgs struct {
+	JobName string
+
+	// default to NextRunsCount
+	Count int
+
+	// default to now
+	From time.Time
+}
+
+// UpcomingArgs asks for the calendar of the fires of the jobs from From until To.
+type UpcomingArgs struct {
+	// default to now
+	From time.Time
+
+	// default to a day after From
+	To time.Time
+
+	// only the jobs of the application if any
+	Application string
+
+	// default to DefaultHotspotThreshold
+	HotspotThreshold int
+}
+
+// NextRuns replies the description and the next fire times of a job, leaving out the blackouts of its calendars.
+func (r *RPCServer) NextRuns(ctx context.Context, args *NextRunsArgs, reply *JobReply) error {
+	job, err := r.agent.store.GetJob(args.JobName)
+	if err != nil {
+		return err
+	}
+
+	calendars, err := r.agent.store.GetCalendars()
+	if err != nil {
+		return err
+	}
+
+	count := args.Count
+	if count <= 0 {
+		count = NextRunsCount
+	}
+	if count > MaxUpcomingFires {
+		count = MaxUpcomingFires
+	}
+	from := args.From
+	if from.IsZero() {
+		from = time.Now()
+	}
+
+	reply.Description, _ = job.Describe()
+	reply.NextRuns, _ = upcomingRuns(job, calendars, from, time.Time{}, count)
+	reply.Success = true
+	return nil
+}
+
+// Upcoming replies the fires of the jobs in a time window grouped by application with the hotspots.
+func (r *RPCServer) Upcoming(ctx context.Context, args *UpcomingArgs, reply *Upcoming) error {
+	jobs, err := r.agent.store.GetJobs()
+	if err != nil {
+		return err
+	}
+
+	calendars, err := r.agent.store.GetCalendars()
+	if err != nil {
+		return err
+	}
+
+	if args.Application != "" {
+		appJobs := make([]*Job, 0)
+		for _, j := range jobs {
+			if j.Application == args.Application {
+				appJobs = append(appJobs, j)
+			}
+		}
+		jobs = appJobs
+	}
+
+	from := args.From
+	if from.IsZero() {
+		from = time.Now()
+	}
+	to := args.To
+	if to.IsZero() {
+		to = from.Add(24 * time.Hour)
+	}
+	if !to.After(from) {
+		return fmt.Errorf("upcoming: %s isn't after %s", to, from)
+	}
+
+	*reply = *PlanUpcoming(jobs, calendars, from, to, args.HotspotThreshold)
+	return nil
+}
+
+// CalendarImport imports the events of an iCalendar (.ics) file into a calendar.
+type CalendarImport struct {
+	Calendar string
+
+	// content of the .ics file
+	ICS string
+
+	// the calendar is created for these applications if it doesn't exist
+	Applications []string
+}
+
+func (r *RPCServer) SetCalendar(ctx context.Context, args *Calendar, reply *RPCReply) error {
+	if err := args.Validate(); err != nil {
+		return err
+	}
+
+	err := r.agent.store.SetCalendar(args)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"calendar": args,
+		}).Error("RPCServer: SetCalendar failed.")
+	} else {
+		reply.Ack = reply.Ack + 1
+		reply.Success = true
+	}
+
+	return err
+}
+
+func (r *RPCServer) DeleteCalendar(ctx context.Context, args *Calendar, reply *RPCReply) error {
+	_, err := r.agent.store.DeleteCalendar(args.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"calendar": args.Name,
+		}).Error("RPCServer: DeleteCalendar failed.")
+	} else {
+		reply.Ack = reply.Ack + 1
+		reply.Success = true
+	}
+
+	return err
+}
+
+// ImportCalendar replaces the events of a calendar with the events of an iCalendar file
+func (r *RPCServer) ImportCalendar(ctx context.Context, args *CalendarImport, reply *RPCReply) error {
+	events, err := ParseICS([]byte(args.ICS))
+	if err != nil {
+		return err
+	}
+
+	c, err := r.agent.store.GetCalendar(args.Calendar)
+	if err != nil {
+		if err != store.ErrKeyNotFound {
+			return err
+		}
+		c = &Calendar{Name: args.Calendar, Applications: args.Applications}
+	}
+	c.Events = events
+
+	return r.SetCalendar(ctx, c, reply)
+}
+
+// QueryExecutions replies a page of the executions matching the query, the latest first.
+func (r *RPCServer) QueryExecutions(ctx context.Context, args *ExecutionQuery, reply *ExecutionPage) error {
+	page, err := r.agent.store.QueryExecutions(args)
+	if err != nil {
+		return err
+	}
+	*reply = *page
+	return nil
+}
+
+// ExecutionProgress records the progress of a running execution reported by a worker, it's a heartbeat
+// of the execution too. The reply isn't successful once the execution has finished, e.g. as stalled.
+func (r *RPCServer) ExecutionProgress(ctx context.Context, args *ExecutionProgress, reply *RPCReply) error {
+	if args.Percent < 0 || args.Percent > 100 {
+		return fmt.Errorf("progress: invalid percentage %v", args.Percent)
+	}
+
+	updated := false
+	_, err := r.agent.store.UpdateExecution(args.JobName, args.ExecutionKey, func(ex *Execution) bool {
+		updated = args.apply(ex, time.Now())
+		return updated
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job":       args.JobName,
+			"execution": args.ExecutionKey,
+			"err":       err,
+		}).Error("RPCServer: ExecutionProgress failed.")
+		return err
+	}
+
+	reply.Ack = reply.Ack + 1
+	reply.Success = updated
+	return nil
+}
+
+// AppendOutput stores a chunk of the output of a running execution, the chunks are read by the tail endpoint
+// of the HTTP listener as they arrive. The reply isn't successful once the output has been truncated.
+func (r *RPCServer) AppendOutput(ctx context.Context, args *OutputChunk, reply *RPCReply) error {
+	if args.JobName == "" || args.ExecutionKey == "" || strings.Contains(args.JobName+args.ExecutionKey, "/") {
+		return fmt.Errorf("output: invalid execution '%s/%s'", args.JobName, args.ExecutionKey)
+	}
+	if len(args.Data) > MaxOutputChunk {
+		return fmt.Errorf("output: chunk of %d bytes exceeds %d bytes", len(args.Data), MaxOutputChunk)
+	}
+
+	idx, err := r.agent.store.AppendOutput(args)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job":       args.JobName,
+			"execution": args.ExecutionKey,
+			"err":       err,
+		}).Error("RPCServer: AppendOutput failed.")
+		return err
+	}
+
+	reply.Ack = reply.Ack + 1
+	reply.Success = !idx.Truncated
+	return nil
+}
+
 func (r *RPCServer) ExecutionDone(ctx context.Context, args *Execution, reply *RPCReply) error {
 	args.mux.Lock()
 	defer args.mux.Unlock()
@@ -88,9 +468,11 @@ func (r *RPCServer) ExecutionDone(ctx context.Context, args *Execution, reply *R
 	//sometimes Done event come earlier than Do event.
 	//done must be executed after do event.
 	prvIsDone := make(chan bool)
+	var prvEx *Execution
 	go func() {
 		for {
-			prvEx, prvErr := r.agent.store.ExistExecution(args)
+			var prvErr error
+			prvEx, prvErr = r.agent.store.ExistExecution(args)
 			if prvErr != nil {
 				log.WithFields(log.Fields{
 					"prvEx":     prvEx,
@@ -112,6 +494,22 @@ func (r *RPCServer) ExecutionDone(ctx context.Context, args *Execution, reply *R
 
 	<-prvIsDone
 
+	// a stalled or cancelled execution has already been finished as failed
+	if !prvEx.FinishedAt.IsZero() {
+		log.WithFields(log.Fields{
+			"execution": args,
+			"stalled":   prvEx.Stalled,
+		}).Info("RPCServer: ExecutionDone of a finished execution.")
+		reply.Ack = reply.Ack + 1
+		return nil
+	}
+
+	// keep the progress reported by ExecutionProgress, the copy of the worker doesn't carry it
+	args.Progress = prvEx.Progress
+	args.Message = prvEx.Message
+	args.Counters = prvEx.Counters
+	args.HeartbeatAt = prvEx.HeartbeatAt
+
 	args.FinishedAt = time.Now()
 	_, err := r.agent.store.SetExecution(args)
 	if err != nil {
@@ -125,6 +523,22 @@ func (r *RPCServer) ExecutionDone(ctx context.Context, args *Execution, reply *R
 		"reply":     reply,
 	}).Debug("RPCServer: ExecutionDone be called by workerRPC.ExecutionDone.")
 
+	m, err := r.agent.store.UpdateJobMetadata(args.JobName, func(m *JobMetaData) bool {
+		if args.Success == true {
+			m.SuccessCount += 1
+			m.LastSuccess = time.Now()
+		} else {
+			m.ErrorCount += 1
+			m.LastError = time.Now()
+		}
+		return true
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("RPCServer: UpdateJobMetadata fail.")
+	}
+
 	job, err := r.agent.store.GetJob(args.JobName)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -132,34 +546,41 @@ func (r *RPCServer) ExecutionDone(ctx context.Context, args *Execution, reply *R
 		}).Error("RPCServer: GetJob fail.")
 	}
 
-	if args.Success == true {
-		job.Metadata.SuccessCount += 1
-		job.Metadata.LastSuccess = time.Now()
-
-	} else {
-		job.Metadata.ErrorCount += 1
-		job.Metadata.LastError = time.Now()
+	if job != nil && job.MaxConcurrent > 0 {
+		r.agent.releaseSlot(args)
 	}
 
-	err = r.agent.store.SetJob(job)
-	if err != nil {
+	if job != nil && m != nil && args.Success && job.MaxRuns > 0 && m.SuccessCount >= job.MaxRuns {
 		log.WithFields(log.Fields{
-			"err": err,
-		}).Error("RPCServer: SetJob fail.")
+			"job":      job.Name,
+			"max_runs": job.MaxRuns,
+		}).Info("RPCServer: job is done after max runs.")
+
+		if _, err := r.agent.store.SetJobDone(job.Name); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("RPCServer: SetJobDone fail.")
+		}
 	}
 
 	go args.DecCounter(args.NodeName, "undo")
 	go args.DecCounter(args.NodeName, args.Tags["type"])
 
+	if job != nil && job.Concurrency == ConcurrencyQueue {
+		go r.agent.RunQueued(args.JobName)
+	}
+
 	reply.Ack = reply.Ack + 1
 	reply.Success = true
 	return nil
 }
 
-func (rc *RPCClient) ExecutionDo(args *Execution) {
+// ExecutionDo sends an execution to the worker nodes, it reports whether one of them has acknowledged it.
+func (rc *RPCClient) ExecutionDo(args *Execution) bool {
 	args.mux.Lock()
 	defer args.mux.Unlock()
 
+	acked := false
 	for _, p := range rc.ServerAddr {
 
 		addr := fmt.Sprintf("tcp@%s:%d", p.IP, p.Port)
@@ -190,6 +611,7 @@ func (rc *RPCClient) ExecutionDo(args *Execution) {
 
 			if rpcReply.Ack > 0 {
 				rc.agent.store.SetExecution(args)
+				acked = true
 			}
 
 			go args.IncCounter(args.NodeName, "undo")
@@ -199,6 +621,32 @@ func (rc *RPCClient) ExecutionDo(args *Execution) {
 
 	}
 
+	return acked
+}
+
+// ExecutionCancel asks the worker nodes to stop running an execution
+func (rc *RPCClient) ExecutionCancel(args *Execution) {
+	for _, p := range rc.ServerAddr {
+		addr := fmt.Sprintf("tcp@%s:%d", p.IP, p.Port)
+
+		d := client.NewPeer2PeerDiscovery(addr, "")
+		rc.xclient = client.NewXClient("Worker", client.Failtry, client.RandomSelect, d, client.DefaultOption)
+		defer rc.xclient.Close()
+
+		rpcReply := &RPCReply{}
+		err := rc.xclient.Call(context.Background(), "ExecutionCancel", args, rpcReply)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":       err,
+				"Execution": args,
+			}).Error("RPCClient: Call Worker.ExecutionCancel failed.")
+		} else {
+			log.WithFields(log.Fields{
+				"rpcReply":  rpcReply,
+				"Execution": args,
+			}).Debug("RPCClient: Call Worker.ExecutionCancel.")
+		}
+	}
 }
 
 //Ping do nothing but just call pong
@@ -227,7 +675,14 @@ func (rc *RPCClient) Ping(node *Processor) {
 				"err": err,
 			}).Error("PING: failed to call")
 
-			err := rc.agent.store.DeleteExecutionsByNodeName(node.NodeName)
+			shards, err := rc.agent.store.GetUnfinishedShards(node.NodeName)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("get unfinished shards after ping failed")
+			}
+
+			err = rc.agent.store.DeleteExecutionsByNodeName(node.NodeName)
 			if err != nil {
 				log.WithFields(log.Fields{
 					"err": err,
@@ -239,6 +694,9 @@ func (rc *RPCClient) Ping(node *Processor) {
 				log.Error("ping error deleting processor: ", err)
 			}
 
+			// the processor is gone, so its shards go to the others
+			rc.agent.ReassignShards(shards)
+
 			break
 		}
 		time.Sleep(2 * time.Second)
@@ -253,7 +711,7 @@ func (rc *RPCClient) SetXClient(ex *Execution) client.XClient {
 		addrKVPair = append(addrKVPair, &client.KVPair{Key: addr})
 	}
 
-	if ex.Concurrency == "allow" {
+	if ex.Target == TargetAll {
 		d := client.NewMultipleServersDiscovery(addrKVPair)
 		rc.xclient = client.NewXClient("Worker", client.Failover, client.RoundRobin, d, client.DefaultOption)
 
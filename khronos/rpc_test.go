@@ -0,0 +1,112 @@
+package khronos
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func createTestRPCServer() *RPCServer {
+	return &RPCServer{agent: createTestAgent(createTestStore())}
+}
+
+//go test -v -run=TestRPCTemplate
+func TestRPCTemplate(t *testing.T) {
+	r := createTestRPCServer()
+	ctx := context.Background()
+
+	tmpl := &Template{
+		Name: "spider",
+		Job: Job{
+			Name:        "spider-${coin}",
+			Schedule:    "@every 5s",
+			JobType:     "rpc",
+			Application: "spider",
+			Payload:     map[string]string{"coin": "${coin}"},
+		},
+	}
+	if err := r.SetTemplate(ctx, tmpl, &RPCReply{}); err != nil {
+		t.Fatalf("error setting template: %s", err)
+	}
+
+	var reply JobReply
+	if err := r.Instantiate(ctx, &InstantiateArgs{Template: "spider", Params: map[string]string{"coin": "eth"}}, &reply); err != nil || !reply.Success {
+		t.Fatalf("error instantiating template: %v %+v", err, reply)
+	}
+	reply = JobReply{}
+	if err := r.Instantiate(ctx, &InstantiateArgs{Template: "spider"}, &reply); err != nil || reply.Success || len(reply.Errors) == 0 {
+		t.Fatalf("expected the missing parameter reported got %v %+v", err, reply)
+	}
+
+	var instances TemplateInstances
+	if err := r.GetTemplateInstances(ctx, tmpl, &instances); err != nil || len(instances.Jobs) != 1 || instances.Jobs[0].Name != "spider-eth" {
+		t.Fatalf("expected the instance spider-eth got %v %+v", err, instances)
+	}
+	if err := r.DeleteTemplate(ctx, tmpl, &RPCReply{}); err == nil {
+		t.Fatalf("expected the template with instances kept")
+	}
+}
+
+//go test -v -run=TestRPCExecutionProgress
+func TestRPCExecutionProgress(t *testing.T) {
+	r := createTestRPCServer()
+	ctx := context.Background()
+
+	ex := &Execution{JobName: "spider", NodeName: "server-001", StartedAt: time.Now()}
+	key, err := r.agent.store.SetExecution(ex)
+	if err != nil {
+		t.Fatalf("error creating execution: %s", err)
+	}
+
+	var reply RPCReply
+	args := &ExecutionProgress{JobName: "spider", ExecutionKey: key, Percent: 40, Message: "crawling"}
+	if err := r.ExecutionProgress(ctx, args, &reply); err != nil || !reply.Success {
+		t.Fatalf("error reporting progress: %v %+v", err, reply)
+	}
+	if err := r.AppendOutput(ctx, &OutputChunk{JobName: "spider", ExecutionKey: key, Data: []byte("page 1\n")}, &reply); err != nil {
+		t.Fatalf("error appending output: %s", err)
+	}
+
+	var page ExecutionPage
+	if err := r.QueryExecutions(ctx, &ExecutionQuery{Status: StateRunning}, &page); err != nil || len(page.Executions) != 1 {
+		t.Fatalf("expected the running execution got %v %+v", err, page)
+	}
+	if got := page.Executions[0]; got.Progress != 40 || got.Message != "crawling" || got.HeartbeatAt.IsZero() {
+		t.Fatalf("unexpected progress of %+v", got)
+	}
+
+	chunks, _, err := r.agent.store.GetOutput("spider", key, 0)
+	if err != nil || len(chunks) != 1 || string(chunks[0].Data) != "page 1\n" {
+		t.Fatalf("unexpected output %v %v", chunks, err)
+	}
+}
+
+//go test -v -run=TestRPCExecutionDoneCancelled
+func TestRPCExecutionDoneCancelled(t *testing.T) {
+	r := createTestRPCServer()
+	ctx := context.Background()
+
+	if err := r.agent.store.SetJob(&Job{Name: "spider", Schedule: "@every 5s", Concurrency: ConcurrencyReplace}); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	ex := &Execution{JobName: "spider", NodeName: "server-001", StartedAt: time.Now()}
+	if _, err := r.agent.store.SetExecution(ex); err != nil {
+		t.Fatalf("error creating execution: %s", err)
+	}
+	r.agent.CancelRunning("spider")
+
+	// the worker ignores the cancel and reports a success later
+	done := &Execution{JobName: "spider", NodeName: "server-001", StartedAt: ex.StartedAt, Success: true}
+	if err := r.ExecutionDone(ctx, done, &RPCReply{}); err != nil {
+		t.Fatalf("error reporting done: %s", err)
+	}
+
+	got, err := r.agent.store.GetExecution("spider", ex.Key())
+	if err != nil || got.Success || got.FinishedAt.IsZero() {
+		t.Fatalf("expected the execution kept cancelled got %+v, %v", got, err)
+	}
+	m, err := r.agent.store.GetJobMetadata("spider")
+	if err != nil || m.SuccessCount != 0 || m.ErrorCount != 1 {
+		t.Fatalf("expected 1 error and no success got %+v, %v", m, err)
+	}
+}
@@ -1,16 +1,29 @@
 package khronos
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/abronan/valkeyrie/store"
 	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// WatchRetry is the first wait before a failed watch is made again, it doubles up to WatchRetryMax.
+	WatchRetry = time.Second
+
+	WatchRetryMax = time.Minute
+)
+
 type Scheduler struct {
 	Cron    *cron.Cron
 	Started bool
 	Agent   *Agent `json:"-"`
+
+	// closed on stop to end the watches of the jobs
+	stopCh chan struct{}
 }
 
 func NewScheduler() *Scheduler {
@@ -20,6 +33,8 @@ func NewScheduler() *Scheduler {
 }
 
 func (s *Scheduler) Start(jobs []*Job) {
+	s.stopCh = make(chan struct{})
+
 	for _, job := range jobs {
 		if job.Disabled {
 			continue
@@ -35,26 +50,178 @@ func (s *Scheduler) Start(jobs []*Job) {
 
 		job.Agent = s.Agent
 
-		schedule := job.Schedule
-		schedule = strings.Trim(schedule, "")
-		if schedule == "@oneway" {
-			job.Run()
-		} else {
-			s.Cron.AddJob(job.Schedule, job)
+		if job.Watch != "" {
+			go s.watch(job, s.stopCh)
+		}
+		// a job may be triggered by its watch only
+		if job.Schedule == "" {
+			continue
+		}
+
+		sched, err := job.CronSchedule()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job":      job.Name,
+				"schedule": job.Schedule,
+				"err":      err,
+			}).Error("scheduler: Invalid schedule")
+			continue
+		}
+
+		if at, ok := sched.(*AtSchedule); ok {
+			// the time of a one shot job has passed while the agent was down,
+			// or it's a @oneway job, so fire it now
+			if !at.At.After(time.Now()) {
+				scheduled := at.At
+				if scheduled.IsZero() {
+					scheduled = time.Now()
+				}
+				go job.fire(scheduled, 0)
+				continue
+			}
+			s.Cron.Schedule(at, job)
+			continue
 		}
 
+		s.Cron.Schedule(sched, job)
+		go s.catchUp(job, sched, time.Now())
 	}
 	s.Cron.Start()
 	s.Started = true
 
 }
 
+// AtSchedule activates once at an absolute time.
+type AtSchedule struct {
+	At time.Time
+}
+
+// Next returns the time of the schedule if it's after the given time,
+// otherwise the zero time which means it won't activate anymore.
+func (s *AtSchedule) Next(t time.Time) time.Time {
+	if s.At.After(t) {
+		return s.At
+	}
+	return time.Time{}
+}
+
+// BoundedSchedule restricts a schedule to a validity window.
+type BoundedSchedule struct {
+	Schedule cron.Schedule
+
+	// zero means no start
+	StartAt time.Time
+
+	// zero means no end
+	EndAt time.Time
+}
+
+// Next returns the next activation of the schedule within the window,
+// or the zero time after the end of the window.
+func (s *BoundedSchedule) Next(t time.Time) time.Time {
+	if !s.StartAt.IsZero() && t.Before(s.StartAt) {
+		// an activation right at the start is in the window
+		t = s.StartAt.Add(-time.Nanosecond)
+	}
+
+	next := s.Schedule.Next(t)
+	if !s.EndAt.IsZero() && next.After(s.EndAt) {
+		return time.Time{}
+	}
+	return next
+}
+
+// ParseSchedule parses the schedule of a job, e.g. "@every 5s", "0 0 * * * *", "0 9 L * *" or "@at 2018-06-08T10:00:00+08:00".
+// The former "@oneway" is a one shot schedule without time, it activates as soon as it's scheduled.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if spec == "@oneway" {
+		return &AtSchedule{}, nil
+	}
+
+	if strings.HasPrefix(spec, "@at ") {
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(strings.TrimPrefix(spec, "@at ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid @at schedule '%s', expected a RFC3339 time: %s", spec, err)
+		}
+		return &AtSchedule{At: at}, nil
+	}
+
+	return ParseCron(spec)
+}
+
+// catchUp applies the misfire policy of a job to the schedules missed
+// since its last schedule, e.g. while the agent was down or restarting.
+func (s *Scheduler) catchUp(job *Job, sched cron.Schedule, now time.Time) {
+	// without agent there's no store to find the last schedule in
+	if s.Agent == nil {
+		return
+	}
+	last, err := s.Agent.store.GetLastScheduled(job.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"job": job.Name,
+			"err": err,
+		}).Error("scheduler: GetLastScheduled fail")
+		return
+	}
+	// never been scheduled, nothing has been missed
+	if last.IsZero() {
+		return
+	}
+
+	limit := 1
+	if job.Misfire == MisfireFireAll {
+		limit = job.MisfireLimit
+		if limit <= 0 {
+			limit = DefaultMisfireLimit
+		}
+	}
+
+	// keep only the latest missed schedules
+	missed := make([]time.Time, 0)
+	count := 0
+	for t := sched.Next(last); !t.IsZero() && t.Before(now); t = sched.Next(t) {
+		count++
+		missed = append(missed, t)
+		if len(missed) > limit {
+			missed = missed[1:]
+		}
+	}
+	if count == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"job":     job.Name,
+		"misfire": job.Misfire,
+		"missed":  count,
+		"last":    last,
+	}).Info("scheduler: Found missed schedules")
+
+	switch job.Misfire {
+	case MisfireFireOnce, MisfireFireAll:
+		for _, t := range missed {
+			job.fire(t, 0)
+		}
+	default:
+		if err := s.Agent.store.SetLastScheduled(job.Name, missed[len(missed)-1]); err != nil {
+			log.WithFields(log.Fields{
+				"job": job.Name,
+				"err": err,
+			}).Error("scheduler: SetLastScheduled fail")
+		}
+	}
+}
+
 func (s *Scheduler) Stop() {
 	if s.Started {
 		log.Debug("scheduler: Stopping scheduler")
 		s.Cron.Stop()
 		s.Started = false
 		s.Cron = cron.New()
+		close(s.stopCh)
 
 	}
 }
@@ -63,3 +230,134 @@ func (s *Scheduler) Restart(jobs []*Job) {
 	s.Stop()
 	s.Start(jobs)
 }
+
+// watchEvent is a change of a watched key.
+type watchEvent struct {
+	Key string
+
+	Value string
+
+	// put or delete
+	Event string
+}
+
+// watch triggers the job on the changes of the keys under its watch prefix until stopCh is closed.
+// The changes made while the scheduler is restarting aren't seen.
+func (s *Scheduler) watch(job *Job, stopCh chan struct{}) {
+	log.WithFields(log.Fields{
+		"job":   job.Name,
+		"watch": job.Watch,
+	}).Debug("scheduler: Watching keys")
+
+	watchPrefix(s.Agent.store, job.Watch, stopCh, func(c watchEvent) {
+		log.WithFields(log.Fields{
+			"job":   job.Name,
+			"key":   c.Key,
+			"event": c.Event,
+		}).Debug("scheduler: Watched key changed")
+
+		go func() {
+			payload := map[string]string{
+				"watch_key":   c.Key,
+				"watch_value": c.Value,
+				"watch_event": c.Event,
+			}
+			if !job.Trigger(payload) {
+				log.WithFields(log.Fields{
+					"job":   job.Name,
+					"key":   c.Key,
+					"event": c.Event,
+				}).Warn("scheduler: Watched change dropped")
+			}
+		}()
+	})
+}
+
+// watchPrefix calls fn on every change of the keys under a prefix until stopCh is closed.
+// A backend may refuse to watch an empty prefix or end the watch when the last key is deleted,
+// like etcd v3, the prefix is then watched again with a backoff and its known keys are deleted.
+func watchPrefix(st Storage, prefix string, stopCh <-chan struct{}, fn func(watchEvent)) {
+	// the first listing is the current state, it doesn't call fn
+	var known map[string]*store.KVPair
+	changed := func(pairs []*store.KVPair) {
+		var changes []watchEvent
+		known, changes = watchChanges(known, pairs)
+		for _, c := range changes {
+			fn(c)
+		}
+	}
+
+	retry := WatchRetry
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		events, err := st.WatchPrefix(prefix, stopCh)
+		switch {
+		case err == store.ErrKeyNotFound:
+			changed(nil)
+		case err != nil:
+			log.WithFields(log.Fields{
+				"watch": prefix,
+				"err":   err,
+			}).Error("scheduler: Watch fail")
+		default:
+			received := false
+		read:
+			for {
+				select {
+				case <-stopCh:
+					return
+				case pairs, ok := <-events:
+					if !ok {
+						break read
+					}
+					received = true
+					changed(pairs)
+				}
+			}
+			// the watch has worked before it ended, it's watched again at once
+			if received {
+				retry = WatchRetry
+				continue
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > WatchRetryMax {
+			retry = WatchRetryMax
+		}
+	}
+}
+
+// watchChanges compares a listing of keys to the known ones, a nil known listing has no change.
+func watchChanges(known map[string]*store.KVPair, pairs []*store.KVPair) (map[string]*store.KVPair, []watchEvent) {
+	current := make(map[string]*store.KVPair)
+	for _, p := range pairs {
+		current[p.Key] = p
+	}
+
+	changes := make([]watchEvent, 0)
+	if known == nil {
+		return current, changes
+	}
+
+	for _, p := range pairs {
+		if old, ok := known[p.Key]; !ok || old.LastIndex != p.LastIndex {
+			changes = append(changes, watchEvent{Key: p.Key, Value: string(p.Value), Event: "put"})
+		}
+	}
+	for key, p := range known {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, watchEvent{Key: key, Value: string(p.Value), Event: "delete"})
+		}
+	}
+	return current, changes
+}
@@ -3,11 +3,14 @@ package khronos
 import (
 	"testing"
 	"time"
+
+	"github.com/abronan/valkeyrie/store"
 )
 
 //go test -v -run=TestSchedule
 func TestSchedule(t *testing.T) {
 	sched := NewScheduler()
+	sched.Agent = createTestAgent(createTestStore())
 
 	testJob1 := &Job{
 		Name:       "cron_job",
@@ -27,3 +30,195 @@ func TestSchedule(t *testing.T) {
 	}
 
 }
+
+//go test -v -run=TestParseSchedule
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2018, 6, 8, 10, 0, 0, 0, time.UTC)
+
+	sched, err := ParseSchedule("@at 2018-06-08T12:00:00+02:00")
+	if err != nil {
+		t.Fatalf("error parsing @at schedule: %s", err)
+	}
+	at, ok := sched.(*AtSchedule)
+	if !ok {
+		t.Fatalf("expected an AtSchedule got: %T", sched)
+	}
+	if next := at.Next(now.Add(-time.Hour)); !next.Equal(now) {
+		t.Fatalf("expected next %s got: %s", now, next)
+	}
+	if next := at.Next(now); !next.IsZero() {
+		t.Fatalf("expected no next after the time got: %s", next)
+	}
+
+	sched, err = ParseSchedule("@oneway")
+	if err != nil {
+		t.Fatalf("error parsing @oneway schedule: %s", err)
+	}
+	if next := sched.Next(now); !next.IsZero() {
+		t.Fatalf("expected @oneway without next got: %s", next)
+	}
+
+	if _, err := ParseSchedule("@at tomorrow"); err == nil {
+		t.Fatal("expected error parsing an invalid @at schedule")
+	}
+
+	sched, err = ParseSchedule("@every 5s")
+	if err != nil {
+		t.Fatalf("error parsing @every schedule: %s", err)
+	}
+	if next := sched.Next(now); !next.Equal(now.Add(5 * time.Second)) {
+		t.Fatalf("expected next in 5s got: %s", next)
+	}
+}
+
+//go test -v -run=TestJobIsOneShot
+func TestJobIsOneShot(t *testing.T) {
+	for schedule, expected := range map[string]bool{
+		"@oneway":                   true,
+		"@at 2018-06-08T10:00:00Z":  true,
+		" @at 2018-06-08T10:00:00Z": true,
+		"@every 5s":                 false,
+		"0 0 * * * *":               false,
+	} {
+		j := &Job{Schedule: schedule}
+		if j.IsOneShot() != expected {
+			t.Fatalf("expected one shot %t for '%s'", expected, schedule)
+		}
+	}
+}
+
+//go test -v -run=TestBoundedSchedule
+func TestBoundedSchedule(t *testing.T) {
+	start := time.Date(2018, 6, 8, 10, 0, 0, 0, time.UTC)
+	job := &Job{
+		Schedule: "0 0 * * * *",
+		StartAt:  start,
+		EndAt:    start.Add(2 * time.Hour),
+	}
+
+	sched, err := job.CronSchedule()
+	if err != nil {
+		t.Fatalf("error parsing schedule: %s", err)
+	}
+
+	if next := sched.Next(start.Add(-24 * time.Hour)); !next.Equal(start) {
+		t.Fatalf("expected first activation at the start %s got: %s", start, next)
+	}
+	if next := sched.Next(start); !next.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected next activation %s got: %s", start.Add(time.Hour), next)
+	}
+	if next := sched.Next(start.Add(time.Hour)); !next.Equal(start.Add(2 * time.Hour)) {
+		t.Fatalf("expected an activation right at the end %s got: %s", start.Add(2*time.Hour), next)
+	}
+	if next := sched.Next(start.Add(2 * time.Hour)); !next.IsZero() {
+		t.Fatalf("expected no activation after the end got: %s", next)
+	}
+}
+
+//go test -v -run=TestWatchChanges
+func TestWatchChanges(t *testing.T) {
+	pairs := []*store.KVPair{
+		{Key: "khronos/markets/btc", Value: []byte("1"), LastIndex: 1},
+		{Key: "khronos/markets/eth", Value: []byte("2"), LastIndex: 2},
+	}
+	known, changes := watchChanges(nil, pairs)
+	if len(changes) != 0 || len(known) != 2 {
+		t.Fatalf("expected no change of the first listing got %v", changes)
+	}
+
+	pairs = []*store.KVPair{
+		{Key: "khronos/markets/btc", Value: []byte("1"), LastIndex: 1},
+		{Key: "khronos/markets/eth", Value: []byte("3"), LastIndex: 3},
+		{Key: "khronos/markets/eos", Value: []byte("4"), LastIndex: 4},
+	}
+	known, changes = watchChanges(known, pairs)
+	if len(changes) != 2 || changes[0].Key != "khronos/markets/eth" || changes[0].Value != "3" || changes[1].Key != "khronos/markets/eos" {
+		t.Fatalf("expected eth and eos put got %v", changes)
+	}
+
+	_, changes = watchChanges(known, pairs[1:])
+	if len(changes) != 1 || changes[0].Key != "khronos/markets/btc" || changes[0].Event != "delete" {
+		t.Fatalf("expected btc deleted got %v", changes)
+	}
+}
+
+// emptyPrefixKV watches like the etcd v3 backend, it refuses to watch an empty prefix
+// and ends the watch when the last key under the prefix is deleted.
+type emptyPrefixKV struct {
+	store.Store
+
+	// signaled when a watch is refused
+	refused chan struct{}
+}
+
+func (kv *emptyPrefixKV) WatchTree(directory string, stopCh <-chan struct{}, options *store.ReadOptions) (<-chan []*store.KVPair, error) {
+	if _, err := kv.List(directory, nil); err == store.ErrKeyNotFound {
+		select {
+		case kv.refused <- struct{}{}:
+		default:
+		}
+		return nil, err
+	}
+
+	events, err := kv.Store.WatchTree(directory, stopCh, options)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range events {
+			if len(pairs) == 0 {
+				return
+			}
+			select {
+			case out <- pairs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+//go test -v -run=TestWatchPrefixEmpty
+func TestWatchPrefixEmpty(t *testing.T) {
+	s := createTestStore()
+	kv := &emptyPrefixKV{Store: s.Client, refused: make(chan struct{}, 1)}
+	s.Client = kv
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	changes := make(chan watchEvent, 10)
+	go watchPrefix(s, "markets", stopCh, func(c watchEvent) {
+		changes <- c
+	})
+
+	next := func() watchEvent {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a change")
+		}
+		return watchEvent{}
+	}
+
+	// the empty prefix is watched again until it has a key
+	<-kv.refused
+	kv.Put("/khronos-test/markets/btc", []byte("1"), nil)
+	if c := next(); c.Key != "khronos-test/markets/btc" || c.Event != "put" {
+		t.Fatalf("expected btc put got %v", c)
+	}
+
+	// the last key deleted ends the watch
+	kv.Delete("/khronos-test/markets/btc")
+	if c := next(); c.Key != "khronos-test/markets/btc" || c.Event != "delete" {
+		t.Fatalf("expected btc deleted got %v", c)
+	}
+
+	kv.Put("/khronos-test/markets/eth", []byte("1"), nil)
+	if c := next(); c.Key != "khronos-test/markets/eth" || c.Event != "put" {
+		t.Fatalf("expected eth put got %v", c)
+	}
+}
@@ -0,0 +1,137 @@
+package khronos
+
+import (
+	"fmt"
+)
+
+const (
+	// SelectorOpEquals matches when the label equals the only value.
+	SelectorOpEquals = "="
+	// SelectorOpNotEquals matches when the label is missing or differs from the only value.
+	SelectorOpNotEquals = "!="
+	// SelectorOpIn matches when the label is one of the values.
+	SelectorOpIn = "in"
+	// SelectorOpNotIn matches when the label is missing or none of the values.
+	SelectorOpNotIn = "notin"
+	// SelectorOpExists matches when the label is present whatever its value.
+	SelectorOpExists = "exists"
+	// SelectorOpDoesNotExist matches when the label is missing.
+	SelectorOpDoesNotExist = "!exists"
+)
+
+// Selector picks the processors a job may be placed on by their labels.
+// All of the match labels and all of the expressions must match.
+// e.g. {"match_labels": {"region": "hk"}, "match_expressions": [{"key": "tier", "operator": "in", "values": ["a", "b"]}]}
+type Selector struct {
+	// equality requirements, a shorthand for "=" expressions
+	MatchLabels map[string]string `json:"match_labels"`
+
+	// equality and set-based requirements
+	MatchExpressions []SelectorRequirement `json:"match_expressions"`
+}
+
+// SelectorRequirement is a single expression of a Selector.
+type SelectorRequirement struct {
+	Key string `json:"key"`
+
+	// =, !=, in, notin, exists, !exists
+	Operator string `json:"operator"`
+
+	Values []string `json:"values"`
+}
+
+// Empty reports whether the selector matches every processor.
+func (s *Selector) Empty() bool {
+	return s == nil || (len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0)
+}
+
+// Validate checks the operators and the number of values of every expression.
+func (s *Selector) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	for _, r := range s.MatchExpressions {
+		if r.Key == "" {
+			return fmt.Errorf("selector: expression without key")
+		}
+
+		switch r.Operator {
+		case SelectorOpEquals, SelectorOpNotEquals:
+			if len(r.Values) != 1 {
+				return fmt.Errorf("selector: operator '%s' on '%s' requires exactly one value", r.Operator, r.Key)
+			}
+		case SelectorOpIn, SelectorOpNotIn:
+			if len(r.Values) == 0 {
+				return fmt.Errorf("selector: operator '%s' on '%s' requires at least one value", r.Operator, r.Key)
+			}
+		case SelectorOpExists, SelectorOpDoesNotExist:
+			if len(r.Values) != 0 {
+				return fmt.Errorf("selector: operator '%s' on '%s' takes no values", r.Operator, r.Key)
+			}
+		default:
+			return fmt.Errorf("selector: unknown operator '%s' on '%s'", r.Operator, r.Key)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the labels satisfy the selector.
+func (s *Selector) Matches(labels map[string]string) bool {
+	if s.Empty() {
+		return true
+	}
+
+	for k, v := range s.MatchLabels {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	for _, r := range s.MatchExpressions {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Matches reports whether the labels satisfy the expression.
+func (r SelectorRequirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+
+	switch r.Operator {
+	case SelectorOpEquals:
+		return ok && len(r.Values) == 1 && v == r.Values[0]
+	case SelectorOpNotEquals:
+		return !ok || len(r.Values) != 1 || v != r.Values[0]
+	case SelectorOpIn:
+		return ok && StringInSlice(v, r.Values)
+	case SelectorOpNotIn:
+		return !ok || !StringInSlice(v, r.Values)
+	case SelectorOpExists:
+		return ok
+	case SelectorOpDoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+// FilterProcessors returns the processors whose labels match the selector.
+func FilterProcessors(processors []*Processor, s *Selector) []*Processor {
+	if s.Empty() {
+		return processors
+	}
+
+	matched := make([]*Processor, 0)
+	for _, p := range processors {
+		if s.Matches(p.Labels) {
+			matched = append(matched, p)
+		}
+	}
+
+	return matched
+}
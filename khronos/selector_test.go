@@ -0,0 +1,83 @@
+package khronos
+
+import (
+	"testing"
+)
+
+//go test -v -run=TestSelectorMatches
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"region": "hk", "gpu": "false", "tier": "a"}
+
+	cases := []struct {
+		selector *Selector
+		expected bool
+	}{
+		{nil, true},
+		{&Selector{}, true},
+		{&Selector{MatchLabels: map[string]string{"region": "hk"}}, true},
+		{&Selector{MatchLabels: map[string]string{"region": "us"}}, false},
+		{&Selector{MatchLabels: map[string]string{"zone": "1"}}, false},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "gpu", Operator: "=", Values: []string{"false"}}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "gpu", Operator: "!=", Values: []string{"true"}}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "zone", Operator: "!=", Values: []string{"1"}}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "tier", Operator: "in", Values: []string{"a", "b"}}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "tier", Operator: "notin", Values: []string{"a", "b"}}}}, false},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "zone", Operator: "notin", Values: []string{"1"}}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "region", Operator: "exists"}}}, true},
+		{&Selector{MatchExpressions: []SelectorRequirement{{Key: "region", Operator: "!exists"}}}, false},
+		{&Selector{
+			MatchLabels:      map[string]string{"region": "hk"},
+			MatchExpressions: []SelectorRequirement{{Key: "tier", Operator: "in", Values: []string{"b"}}},
+		}, false},
+	}
+
+	for i, c := range cases {
+		if got := c.selector.Matches(labels); got != c.expected {
+			t.Fatalf("case %d: expected %t got %t for %+v", i, c.expected, got, c.selector)
+		}
+	}
+}
+
+//go test -v -run=TestSelectorValidate
+func TestSelectorValidate(t *testing.T) {
+	valid := &Selector{MatchExpressions: []SelectorRequirement{
+		{Key: "region", Operator: "=", Values: []string{"hk"}},
+		{Key: "tier", Operator: "in", Values: []string{"a", "b"}},
+		{Key: "gpu", Operator: "exists"},
+	}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid selector got: %s", err)
+	}
+
+	invalid := []SelectorRequirement{
+		{Key: "", Operator: "exists"},
+		{Key: "region", Operator: "~", Values: []string{"hk"}},
+		{Key: "region", Operator: "=", Values: []string{"hk", "us"}},
+		{Key: "tier", Operator: "in"},
+		{Key: "gpu", Operator: "exists", Values: []string{"true"}},
+	}
+	for _, r := range invalid {
+		s := &Selector{MatchExpressions: []SelectorRequirement{r}}
+		if err := s.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", r)
+		}
+	}
+}
+
+//go test -v -run=TestFilterProcessors
+func TestFilterProcessors(t *testing.T) {
+	processors := []*Processor{
+		{NodeName: "server-001", Labels: map[string]string{"region": "hk"}},
+		{NodeName: "server-002", Labels: map[string]string{"region": "us"}},
+		{NodeName: "server-003"},
+	}
+
+	if got := FilterProcessors(processors, nil); len(got) != 3 {
+		t.Fatalf("expected all processors got: %d", len(got))
+	}
+
+	got := FilterProcessors(processors, &Selector{MatchLabels: map[string]string{"region": "hk"}})
+	if len(got) != 1 || got[0].NodeName != "server-001" {
+		t.Fatalf("expected server-001 got: %v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package khronos
+
+import (
+	"time"
+
+	"github.com/abronan/valkeyrie/store"
+)
+
+// Storage persists the jobs, the executions and the state of the cluster,
+// Store implements it on a valkeyrie backend.
+type Storage interface {
+	// jobs
+	SetJob(job *Job) error
+	SetJobDone(name string) (bool, error)
+	GetJobs() ([]*Job, error)
+	GetJob(name string) (*Job, error)
+	DeleteJob(name string) (*Job, error)
+	GetJobMetadata(name string) (*JobMetaData, error)
+	UpdateJobMetadata(name string, update func(m *JobMetaData) bool) (*JobMetaData, error)
+	WatchJobsTree() (<-chan []*store.KVPair, error)
+	WatchPrefix(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error)
+
+	// schedules
+	QueueJob(name string, scheduled time.Time) error
+	DequeueJob(name string) (time.Time, error)
+	TakeSlot(name string, group int64, max int) (bool, error)
+	ReleaseSlot(name string, group int64) error
+	SetLastScheduled(name string, scheduled time.Time) error
+	GetLastScheduled(name string) (time.Time, error)
+	ClaimIdempotencyKey(jobName string, key string, ttl time.Duration) (bool, error)
+	ReleaseIdempotencyKey(jobName string, key string) error
+
+	// calendars and templates
+	SetCalendar(c *Calendar) error
+	GetCalendar(name string) (*Calendar, error)
+	GetCalendars() ([]*Calendar, error)
+	DeleteCalendar(name string) (*Calendar, error)
+	SetTemplate(t *Template) error
+	GetTemplate(name string) (*Template, error)
+	GetTemplates() ([]*Template, error)
+	DeleteTemplate(name string) (*Template, error)
+	GetTemplateInstances(name string) ([]*Job, error)
+
+	// processors
+	SetProcessor(p *Processor) error
+	GetProcessor(app string, addr string) (*Processor, error)
+	DeleteProcessor(app string, addr string) (*Processor, error)
+	GetProcessors() ([]*Processor, error)
+	GetProcessorsByApp(app string) ([]*Processor, error)
+	GetProcessorByNodeName(app string, nodeName string) (*Processor, error)
+	GetProcessorAddrs(app string) (map[string]string, error)
+	WatchProcessorTree() (<-chan []*store.KVPair, error)
+
+	// executions
+	SetExecution(execution *Execution) (string, error)
+	ExistExecution(execution *Execution) (*Execution, error)
+	GetExecution(jobName string, key string) (*Execution, error)
+	UpdateExecution(jobName string, key string, update func(ex *Execution) bool) (*Execution, error)
+	GetExecutionsAll() ([]*Execution, error)
+	GetExecutions(jobName string) ([]*Execution, error)
+	GetLastExecutionGroup(jobName string) ([]*Execution, error)
+	GetExecutionGroup(execution *Execution) ([]*Execution, error)
+	GetRunningExecutions(jobName string) ([]*Execution, error)
+	GetUnfinishedShards(nodeName string) ([]*Execution, error)
+	QueryExecutions(q *ExecutionQuery) (*ExecutionPage, error)
+	DeleteExecution(ex *Execution) error
+	DeleteExecutions(jobName string) error
+	DeleteExecutionsByNodeName(nodeName string) error
+	EnsureIndexes() error
+	Reindex() error
+
+	// output
+	AppendOutput(chunk *OutputChunk) (*OutputIndex, error)
+	GetOutput(jobName string, key string, after int) ([]*OutputChunk, *OutputIndex, error)
+	DeleteOutput(jobName string, key string) error
+}
+
+var _ Storage = (*Store)(nil)
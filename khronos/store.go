@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/abronan/valkeyrie"
 	"github.com/abronan/valkeyrie/store"
@@ -11,8 +14,16 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// MaxExecutions is the number of executions of a job kept by default.
 const MaxExecutions = 200
 
+// IndexVersion is the version of the indexes of the executions, a store indexed by another version is reindexed.
+const IndexVersion = "2"
+
+// SlotGrace is how long a slot of a job is kept for a group without running execution,
+// e.g. while it's being sent, before another group may take it over.
+const SlotGrace = time.Minute
+
 type Store struct {
 	Client   store.Store
 	keyspace string
@@ -21,12 +32,14 @@ type Store struct {
 
 func init() {
 	etcd.Register()
+	valkeyrie.AddStore(Memory, NewMemoryKV)
+	valkeyrie.AddStore(store.BOLTDB, newBoltKV)
 }
 
 func NewStore(backend string, machines []string, keyspace string) *Store {
 	s, err := valkeyrie.NewStore(store.Backend(backend), machines, nil)
 	if err != nil {
-		log.Error(err)
+		log.WithError(err).Fatal("store: Store backend can't be opened")
 	}
 
 	log.WithFields(log.Fields{
@@ -43,45 +56,165 @@ func NewStore(backend string, machines []string, keyspace string) *Store {
 	return &Store{Client: s, keyspace: keyspace, backend: backend}
 }
 
-// Store a job
+// Store a job, its metadata is stored apart and updated by UpdateJobMetadata only.
 func (s *Store) SetJob(job *Job) error {
 	jobKey := fmt.Sprintf("%s/jobs/%s", s.keyspace, job.Name)
 
-	// Get if the requested job already exist
-	ej, err := s.GetJob(job.Name)
-	if err != nil && err != store.ErrKeyNotFound {
+	for {
+		// Get if the requested job already exist
+		pair, err := s.Client.Get(jobKey, nil)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+
+		if pair != nil {
+			var ej Job
+			if err := json.Unmarshal(pair.Value, &ej); err != nil {
+				return err
+			}
+
+			// the metadata of a job stored along with it is moved to its own key first
+			if ej.Metadata.SuccessCount != 0 || ej.Metadata.ErrorCount != 0 || ej.Metadata.LastGroup != 0 {
+				if _, err := s.UpdateJobMetadata(job.Name, func(m *JobMetaData) bool { return true }); err != nil {
+					return err
+				}
+			}
+			// a done job stays done unless it's rescheduled
+			if ej.IsDone && ej.Schedule == job.Schedule && ej.MaxRuns == job.MaxRuns {
+				job.IsDone = true
+			}
+		}
+
+		metadata := job.Metadata
+		job.Metadata = JobMetaData{}
+		jobJSON, _ := json.Marshal(job)
+		// the secret isn't logged
+		secret := job.WebhookSecret
+		job.WebhookSecret = redact(secret)
+		logJSON, _ := json.Marshal(job)
+		job.WebhookSecret = secret
+		job.Metadata = metadata
+
+		log.WithFields(log.Fields{
+			"job":  job.Name,
+			"json": string(logJSON),
+		}).Debug("store: Setting job")
+
+		_, _, err = s.Client.AtomicPut(jobKey, jobJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			continue
+		}
 		return err
 	}
+}
 
-	if ej != nil {
-		// When the job runs, these status vars are updated
-		// otherwise use the ones that are stored
-		if ej.Metadata.LastError.After(job.Metadata.LastError) {
-			job.Metadata.LastError = ej.Metadata.LastError
+// GetJobMetadata returns the metadata of a job.
+func (s *Store) GetJobMetadata(name string) (*JobMetaData, error) {
+	pair, err := s.Client.Get(s.metadataKey(name), nil)
+	if err == store.ErrKeyNotFound {
+		// the metadata of a job stored along with it
+		job, err := s.getJobDefinition(name)
+		if err != nil {
+			return nil, err
 		}
-		if ej.Metadata.LastSuccess.After(job.Metadata.LastSuccess) {
-			job.Metadata.LastSuccess = ej.Metadata.LastSuccess
+		return &job.Metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m JobMetaData
+	if err := json.Unmarshal(pair.Value, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UpdateJobMetadata applies the update to the metadata of a job atomically, retried on conflicts.
+// The update reports false to leave the metadata as it is.
+func (s *Store) UpdateJobMetadata(name string, update func(m *JobMetaData) bool) (*JobMetaData, error) {
+	key := s.metadataKey(name)
+
+	for {
+		var m JobMetaData
+		pair, err := s.Client.Get(key, nil)
+		switch {
+		case err == store.ErrKeyNotFound:
+			// created from the metadata of a job stored along with it
+			job, err := s.getJobDefinition(name)
+			if err != nil {
+				return nil, err
+			}
+			m = job.Metadata
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(pair.Value, &m); err != nil {
+				return nil, err
+			}
 		}
-		if ej.Metadata.SuccessCount > job.Metadata.SuccessCount {
-			job.Metadata.SuccessCount = ej.Metadata.SuccessCount
+
+		if !update(&m) {
+			return &m, nil
 		}
-		if ej.Metadata.ErrorCount > job.Metadata.ErrorCount {
-			job.Metadata.ErrorCount = ej.Metadata.ErrorCount
+
+		mJSON, _ := json.Marshal(&m)
+		_, _, err = s.Client.AtomicPut(key, mJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
+
+		log.WithFields(log.Fields{
+			"job":      name,
+			"metadata": string(mJSON),
+		}).Debug("store: Updated job metadata")
+
+		return &m, nil
 	}
+}
 
-	jobJSON, _ := json.Marshal(job)
+func (s *Store) metadataKey(name string) string {
+	return fmt.Sprintf("%s/metadata/%s", s.keyspace, name)
+}
 
-	log.WithFields(log.Fields{
-		"job":  job.Name,
-		"json": string(jobJSON),
-	}).Debug("store: Setting job")
+// SetJobDone marks a job done, it reports false if it had already been done.
+func (s *Store) SetJobDone(name string) (bool, error) {
+	jobKey := fmt.Sprintf("%s/jobs/%s", s.keyspace, name)
 
-	if err := s.Client.Put(jobKey, jobJSON, nil); err != nil {
-		return err
-	}
+	for {
+		pair, err := s.Client.Get(jobKey, nil)
+		if err != nil {
+			return false, err
+		}
 
-	return nil
+		var job Job
+		if err := json.Unmarshal(pair.Value, &job); err != nil {
+			return false, err
+		}
+		if job.IsDone {
+			return false, nil
+		}
+
+		job.IsDone = true
+		jobJSON, _ := json.Marshal(&job)
+
+		_, _, err = s.Client.AtomicPut(jobKey, jobJSON, pair, nil)
+		if err == store.ErrKeyModified {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		log.WithFields(log.Fields{
+			"job": name,
+		}).Debug("store: Job done")
+
+		return true, nil
+	}
 }
 
 // GetJobs returns all jobs
@@ -95,6 +228,16 @@ func (s *Store) GetJobs() ([]*Job, error) {
 		return nil, err
 	}
 
+	metadata, err := s.Client.List(s.keyspace+"/metadata/", nil)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	byName := make(map[string][]byte)
+	for _, node := range metadata {
+		path := store.SplitKey(node.Key)
+		byName[path[len(path)-1]] = node.Value
+	}
+
 	jobs := make([]*Job, 0)
 	for _, node := range res {
 		var job Job
@@ -102,27 +245,48 @@ func (s *Store) GetJobs() ([]*Job, error) {
 		if err != nil {
 			return nil, err
 		}
+		if value, ok := byName[job.Name]; ok {
+			job.Metadata = JobMetaData{}
+			if err := json.Unmarshal(value, &job.Metadata); err != nil {
+				return nil, err
+			}
+		}
 		jobs = append(jobs, &job)
 	}
 	return jobs, nil
 }
 
-// Get a job
+// Get a job with its metadata
 func (s *Store) GetJob(name string) (*Job, error) {
-	res, err := s.Client.Get(s.keyspace+"/jobs/"+name, nil)
+	job, err := s.getJobDefinition(name)
 	if err != nil {
 		return nil, err
 	}
 
-	var job Job
-	if err = json.Unmarshal([]byte(res.Value), &job); err != nil {
+	m, err := s.GetJobMetadata(name)
+	if err != nil {
 		return nil, err
 	}
+	job.Metadata = *m
 
 	log.WithFields(log.Fields{
 		"job": job.Name,
 	}).Debug("store: Retrieved job from datastore")
 
+	return job, nil
+}
+
+// getJobDefinition returns a job as it's stored, without its metadata unless it's stored along with it.
+func (s *Store) getJobDefinition(name string) (*Job, error) {
+	res, err := s.Client.Get(s.keyspace+"/jobs/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err = json.Unmarshal([]byte(res.Value), &job); err != nil {
+		return nil, err
+	}
 	return &job, nil
 }
 
@@ -135,6 +299,9 @@ func (s *Store) DeleteJob(name string) (*Job, error) {
 	if err := s.Client.Delete(s.keyspace + "/jobs/" + name); err != nil {
 		return nil, err
 	}
+	if err := s.Client.Delete(s.metadataKey(name)); err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
 
 	return job, nil
 }
@@ -167,6 +334,335 @@ func (s *Store) WatchJobsTree() (<-chan []*store.KVPair, error) {
 	return events, err
 }
 
+// WatchPrefix watches the keys under a prefix of the keyspace until stopCh is closed,
+// every change sends the whole listing of the keys. The etcd v3 backend returns
+// store.ErrKeyNotFound for an empty prefix and closes the channel when the last key is deleted.
+func (s *Store) WatchPrefix(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	dir := fmt.Sprintf("%s/%s", s.keyspace, strings.Trim(prefix, "/"))
+	return s.Client.WatchTree(dir, stopCh, nil)
+}
+
+// QueueJob defers a schedule of a job until its running executions finish,
+// the deferred schedules of a job are merged into the first one.
+func (s *Store) QueueJob(name string, scheduled time.Time) error {
+	key := fmt.Sprintf("%s/queue/%s", s.keyspace, name)
+	value, _ := scheduled.MarshalText()
+
+	_, _, err := s.Client.AtomicPut(key, value, nil, nil)
+	if err == store.ErrKeyExists || err == store.ErrKeyModified {
+		return nil
+	}
+	return err
+}
+
+// TakeSlot takes one of the max slots of the running executions of a job for a group atomically,
+// it reports false when they're all taken. A slot left by a group which isn't running anymore is taken over.
+func (s *Store) TakeSlot(name string, group int64, max int) (bool, error) {
+	value := []byte(strconv.FormatInt(group, 10))
+
+	for i := 0; i < max; i++ {
+		key := fmt.Sprintf("%s/slots/%s/%d", s.keyspace, name, i)
+		_, _, err := s.Client.AtomicPut(key, value, nil, nil)
+		if err == nil {
+			return true, nil
+		}
+		if err != store.ErrKeyExists && err != store.ErrKeyModified {
+			return false, err
+		}
+
+		pair, err := s.Client.Get(key, nil)
+		if err == store.ErrKeyNotFound {
+			// released meanwhile, it's left to the next take
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		held, err := strconv.ParseInt(string(pair.Value), 10, 64)
+		if err == nil && time.Since(time.Unix(0, held)) < SlotGrace {
+			continue
+		}
+		if err == nil {
+			running, err := queryAll(s, ExecutionQuery{Job: name, Group: held, Status: StateRunning})
+			if err != nil {
+				return false, err
+			}
+			if len(running) > 0 {
+				continue
+			}
+		}
+
+		_, _, err = s.Client.AtomicPut(key, value, pair, nil)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"job":   name,
+				"slot":  i,
+				"group": string(pair.Value),
+			}).Info("store.TakeSlot: Took over a slot left by a group which isn't running")
+			return true, nil
+		}
+		if err != store.ErrKeyModified && err != store.ErrKeyNotFound {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// ReleaseSlot releases the slot of a job taken for a group, if any.
+func (s *Store) ReleaseSlot(name string, group int64) error {
+	value := strconv.FormatInt(group, 10)
+
+	pairs, err := s.Client.List(fmt.Sprintf("%s/slots/%s/", s.keyspace, name), nil)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if string(pair.Value) != value {
+			continue
+		}
+		// taken over meanwhile
+		if _, err := s.Client.AtomicDelete(pair.Key, pair); err != nil && err != store.ErrKeyModified && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// DequeueJob removes the deferred schedule of a job and returns its time,
+// it returns the zero time if there is none or if another one has removed it.
+func (s *Store) DequeueJob(name string) (time.Time, error) {
+	var scheduled time.Time
+
+	key := fmt.Sprintf("%s/queue/%s", s.keyspace, name)
+	pair, err := s.Client.Get(key, nil)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return scheduled, nil
+		}
+		return scheduled, err
+	}
+
+	ok, err := s.Client.AtomicDelete(key, pair)
+	if err == store.ErrKeyNotFound || err == store.ErrKeyModified || !ok {
+		return scheduled, nil
+	}
+	if err != nil {
+		return scheduled, err
+	}
+
+	err = scheduled.UnmarshalText(pair.Value)
+	return scheduled, err
+}
+
+// SetLastScheduled records the time of the last schedule of a job,
+// it's never moved backwards.
+func (s *Store) SetLastScheduled(name string, scheduled time.Time) error {
+	key := fmt.Sprintf("%s/schedules/%s", s.keyspace, name)
+	value, _ := scheduled.MarshalText()
+
+	for {
+		pair, err := s.Client.Get(key, nil)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+
+		if pair != nil {
+			var last time.Time
+			if err := last.UnmarshalText(pair.Value); err == nil && !scheduled.After(last) {
+				return nil
+			}
+		}
+
+		_, _, err = s.Client.AtomicPut(key, value, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			continue
+		}
+		return err
+	}
+}
+
+// GetLastScheduled returns the time of the last schedule of a job,
+// or the zero time if it has never been scheduled.
+func (s *Store) GetLastScheduled(name string) (time.Time, error) {
+	var last time.Time
+
+	res, err := s.Client.Get(fmt.Sprintf("%s/schedules/%s", s.keyspace, name), nil)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return last, nil
+		}
+		return last, err
+	}
+
+	err = last.UnmarshalText(res.Value)
+	return last, err
+}
+
+// ClaimIdempotencyKey records an idempotency key of the requests to a job for the ttl,
+// it reports false if the key has already been recorded.
+func (s *Store) ClaimIdempotencyKey(jobName string, key string, ttl time.Duration) (bool, error) {
+	k := fmt.Sprintf("%s/idempotency/%s/%s", s.keyspace, jobName, key)
+	value, _ := time.Now().MarshalText()
+
+	_, _, err := s.Client.AtomicPut(k, value, nil, &store.WriteOptions{TTL: ttl})
+	if err == store.ErrKeyExists || err == store.ErrKeyModified {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseIdempotencyKey forgets an idempotency key, so the request can be retried.
+func (s *Store) ReleaseIdempotencyKey(jobName string, key string) error {
+	return s.Client.Delete(fmt.Sprintf("%s/idempotency/%s/%s", s.keyspace, jobName, key))
+}
+
+// Store a calendar
+func (s *Store) SetCalendar(c *Calendar) error {
+	cJSON, _ := json.Marshal(c)
+
+	log.WithFields(log.Fields{
+		"calendar": c.Name,
+		"json":     string(cJSON),
+	}).Debug("store: Setting calendar")
+
+	return s.Client.Put(fmt.Sprintf("%s/calendars/%s", s.keyspace, c.Name), cJSON, nil)
+}
+
+// Get a calendar
+func (s *Store) GetCalendar(name string) (*Calendar, error) {
+	res, err := s.Client.Get(fmt.Sprintf("%s/calendars/%s", s.keyspace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Calendar
+	if err = json.Unmarshal(res.Value, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetCalendars returns all calendars
+func (s *Store) GetCalendars() ([]*Calendar, error) {
+	res, err := s.Client.List(s.keyspace+"/calendars/", nil)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return []*Calendar{}, nil
+		}
+		return nil, err
+	}
+
+	calendars := make([]*Calendar, 0)
+	for _, node := range res {
+		var c Calendar
+		if err := json.Unmarshal(node.Value, &c); err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, &c)
+	}
+	return calendars, nil
+}
+
+func (s *Store) DeleteCalendar(name string) (*Calendar, error) {
+	c, err := s.GetCalendar(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Client.Delete(fmt.Sprintf("%s/calendars/%s", s.keyspace, name)); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Store a template
+func (s *Store) SetTemplate(t *Template) error {
+	tJSON, _ := json.Marshal(t)
+	// the secret isn't logged
+	secret := t.Job.WebhookSecret
+	t.Job.WebhookSecret = redact(secret)
+	logJSON, _ := json.Marshal(t)
+	t.Job.WebhookSecret = secret
+
+	log.WithFields(log.Fields{
+		"template": t.Name,
+		"json":     string(logJSON),
+	}).Debug("store: Setting template")
+
+	return s.Client.Put(fmt.Sprintf("%s/templates/%s", s.keyspace, t.Name), tJSON, nil)
+}
+
+// Get a template
+func (s *Store) GetTemplate(name string) (*Template, error) {
+	res, err := s.Client.Get(fmt.Sprintf("%s/templates/%s", s.keyspace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var t Template
+	if err = json.Unmarshal(res.Value, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Store) DeleteTemplate(name string) (*Template, error) {
+	t, err := s.GetTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Client.Delete(fmt.Sprintf("%s/templates/%s", s.keyspace, name)); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// GetTemplates returns all templates
+func (s *Store) GetTemplates() ([]*Template, error) {
+	res, err := s.Client.List(s.keyspace+"/templates/", nil)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return []*Template{}, nil
+		}
+		return nil, err
+	}
+
+	templates := make([]*Template, 0)
+	for _, node := range res {
+		var t Template
+		if err := json.Unmarshal(node.Value, &t); err != nil {
+			return nil, err
+		}
+		templates = append(templates, &t)
+	}
+	return templates, nil
+}
+
+// GetTemplateInstances returns the jobs instantiated from a template
+func (s *Store) GetTemplateInstances(name string) ([]*Job, error) {
+	jobs, err := s.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]*Job, 0)
+	for _, j := range jobs {
+		if j.Template == name {
+			instances = append(instances, j)
+		}
+	}
+	return instances, nil
+}
+
 // Store a processor
 func (s *Store) SetProcessor(p *Processor) error {
 	addr := fmt.Sprintf("%s:%d", p.IP, p.Port)
@@ -276,6 +772,21 @@ func (s *Store) GetProcessorsByApp(app string) ([]*Processor, error) {
 	return proces, nil
 }
 
+// GetProcessorByNodeName returns the processor of an app by its node name
+func (s *Store) GetProcessorByNodeName(app string, nodeName string) (*Processor, error) {
+	proces, err := s.GetProcessorsByApp(app)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range proces {
+		if p.NodeName == nodeName {
+			return p, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
 func (s *Store) GetProcessorAddrs(app string) (map[string]string, error) {
 	var addrKVPair map[string]string
 	addrs, err := s.GetProcessorsByApp(app)
@@ -333,6 +844,9 @@ func (s *Store) GetExecutionsAll() ([]*Execution, error) {
 	var executions []*Execution
 
 	for _, node := range res {
+		if !s.isExecutionKey(node.Key) {
+			continue
+		}
 		var execution Execution
 		err := json.Unmarshal([]byte(node.Value), &execution)
 		if err != nil {
@@ -371,47 +885,45 @@ func (s *Store) GetExecutions(jobName string) ([]*Execution, error) {
 }
 
 func (s *Store) GetLastExecutionGroup(jobName string) ([]*Execution, error) {
-	res, err := s.Client.List(fmt.Sprintf("%s/executions/%s", s.keyspace, jobName), nil)
+	refs, err := s.listIndex(fmt.Sprintf("%s/index/group/%s/", s.keyspace, jobName))
 	if err != nil {
 		return nil, err
 	}
-	if len(res) == 0 {
+
+	// the groups are zero padded in the index, so the last one is the greatest
+	last := ""
+	for _, ref := range refs {
+		if ref.group > last {
+			last = ref.group
+		}
+	}
+	if last == "" {
 		return []*Execution{}, nil
 	}
 
-	var lastEx Execution
-	var ex Execution
-	// res does not guarantee any order,
-	// so compare them by `StartedAt` time and get the last one
-	for _, node := range res {
-		err := json.Unmarshal([]byte(node.Value), &ex)
-		if err != nil {
-			return nil, err
-		}
-		if ex.StartedAt.After(lastEx.StartedAt) {
-			lastEx = ex
-		}
+	group, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return nil, err
 	}
-	return s.GetExecutionGroup(&lastEx)
+	return s.GetExecutionGroup(&Execution{JobName: jobName, Group: group})
 }
 
 func (s *Store) GetExecutionGroup(execution *Execution) ([]*Execution, error) {
-	res, err := s.Client.List(fmt.Sprintf("%s/executions/%s", s.keyspace, execution.JobName), nil)
+	refs, err := s.listIndex(fmt.Sprintf("%s/index/group/%s/%019d/", s.keyspace, execution.JobName, execution.Group))
 	if err != nil {
 		return nil, err
 	}
 
 	var executions []*Execution
-	for _, node := range res {
-		var ex Execution
-		err := json.Unmarshal([]byte(node.Value), &ex)
+	for _, ref := range refs {
+		ex, err := s.GetExecution(ref.job, ref.key)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-
-		if ex.Group == execution.Group {
-			executions = append(executions, &ex)
-		}
+		executions = append(executions, ex)
 	}
 	return executions, nil
 }
@@ -463,8 +975,18 @@ func (s *Store) ExistExecution(execution *Execution) (*Execution, error) {
 func (s *Store) SetExecution(execution *Execution) (string, error) {
 	exJson, _ := json.Marshal(execution)
 	key := execution.Key()
+	exKey := fmt.Sprintf("%s/executions/%s/%s", s.keyspace, execution.JobName, key)
+
+	// the indexes of the former state are replaced
+	var prev *Execution
+	if pair, err := s.Client.Get(exKey, nil); err == nil {
+		prev = &Execution{}
+		if err := json.Unmarshal(pair.Value, prev); err != nil {
+			prev = nil
+		}
+	}
 
-	err := s.Client.Put(fmt.Sprintf("%s/executions/%s/%s", s.keyspace, execution.JobName, key), exJson, nil)
+	err := s.Client.Put(exKey, exJson, nil)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"job":       execution.JobName,
@@ -481,41 +1003,226 @@ func (s *Store) SetExecution(execution *Execution) (string, error) {
 		"err":       err,
 	}).Debug("store: Setting key")
 
-	execs, err := s.GetExecutions(execution.JobName)
+	if err := s.reindex(prev, execution); err != nil {
+		return key, err
+	}
+	if err := s.trackGroup(prev, execution); err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// DeleteExecution removes an execution with its output.
+func (s *Store) DeleteExecution(ex *Execution) error {
+	key := ex.Key()
+	err := s.Client.Delete(fmt.Sprintf("%s/executions/%s/%s", s.keyspace, ex.JobName, key))
+	if err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	if err := s.reindex(ex, nil); err != nil {
+		return err
+	}
+	if err := s.trackGroup(ex, nil); err != nil {
+		return err
+	}
+	return s.DeleteOutput(ex.JobName, key)
+}
+
+// GetExecution returns an execution of a job by its key.
+func (s *Store) GetExecution(jobName string, key string) (*Execution, error) {
+	res, err := s.Client.Get(fmt.Sprintf("%s/executions/%s/%s", s.keyspace, jobName, key), nil)
 	if err != nil {
-		log.Errorf("store: No executions found for job %s", execution.JobName)
+		return nil, err
 	}
 
-	// Delete all success of execution results over the limit, starting from olders
-	if len(execs) > MaxExecutions {
-		//sort the array of all execution groups by StartedAt time
-		sort.Sort(ExecList(execs))
-		for i := 0; i < len(execs); i++ {
-			if execs[i].Success == true {
-				log.WithFields(log.Fields{
-					"job":       execs[i].JobName,
-					"execution": execs[i].Key(),
-				}).Debug("store: to detele key")
-				err := s.Client.Delete(fmt.Sprintf("%s/executions/%s/%s", s.keyspace, execs[i].JobName, execs[i].Key()))
-				if err != nil {
-					log.Errorf("store: Trying to delete overflowed execution %s", execs[i].Key())
-				}
+	var ex Execution
+	if err = json.Unmarshal(res.Value, &ex); err != nil {
+		return nil, err
+	}
+	return &ex, nil
+}
+
+// UpdateExecution applies the update to an execution atomically, the update reports false to leave it as it is.
+func (s *Store) UpdateExecution(jobName string, key string, update func(ex *Execution) bool) (*Execution, error) {
+	exKey := fmt.Sprintf("%s/executions/%s/%s", s.keyspace, jobName, key)
+
+	for {
+		pair, err := s.Client.Get(exKey, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var ex Execution
+		if err = json.Unmarshal(pair.Value, &ex); err != nil {
+			return nil, err
+		}
+		var prev Execution
+		if err = json.Unmarshal(pair.Value, &prev); err != nil {
+			return nil, err
+		}
+		if !update(&ex) {
+			return &ex, nil
+		}
+
+		exJSON, _ := json.Marshal(&ex)
+		_, _, err = s.Client.AtomicPut(exKey, exJSON, pair, nil)
+		if err == store.ErrKeyModified {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.reindex(&prev, &ex); err != nil {
+			return &ex, err
+		}
+		return &ex, s.trackGroup(&prev, &ex)
+	}
+}
+
+// isExecutionKey reports whether the key is an execution, not a key nested under an execution such as its output.
+func (s *Store) isExecutionKey(key string) bool {
+	// zookeeper lists the children only
+	if store.Backend(s.backend) == store.ZK {
+		return true
+	}
+	path := store.SplitKey(key)
+	return len(path) >= 3 && path[len(path)-3] == "executions"
+}
+
+// AppendOutput appends a chunk to the output of an execution, stored under the key of the execution
+// as an index and numbered chunks. Once the output reaches MaxOutputSize it's ended with TruncationMarker
+// and further chunks are dropped.
+func (s *Store) AppendOutput(chunk *OutputChunk) (*OutputIndex, error) {
+	dir := s.outputDir(chunk.JobName, chunk.ExecutionKey)
+
+	for {
+		var idx OutputIndex
+		pair, err := s.Client.Get(dir, nil)
+		if err != nil && err != store.ErrKeyNotFound {
+			return nil, err
+		}
+		if err == store.ErrKeyNotFound {
+			pair = nil
+		} else if err := json.Unmarshal(pair.Value, &idx); err != nil {
+			return nil, err
+		}
+
+		if idx.Truncated {
+			return &idx, nil
+		}
+
+		// claim the sequence numbers of the chunks first
+		next, data := idx.next(chunk.Data)
+		idxJSON, _ := json.Marshal(&next)
+		_, _, err = s.Client.AtomicPut(dir, idxJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk.Seq = idx.Chunks + 1
+		if err := s.Client.Put(fmt.Sprintf("%s/%08d", dir, chunk.Seq), data, nil); err != nil {
+			return nil, err
+		}
+		if next.Truncated {
+			if err := s.Client.Put(fmt.Sprintf("%s/%08d", dir, chunk.Seq+1), []byte(TruncationMarker), nil); err != nil {
+				return nil, err
 			}
+		}
+
+		return &next, nil
+	}
+}
+
+// GetOutput returns at most MaxOutputRead chunks of the output of an execution after the given sequence number,
+// it stops at a chunk which has been claimed but not stored yet.
+func (s *Store) GetOutput(jobName string, key string, after int) ([]*OutputChunk, *OutputIndex, error) {
+	dir := s.outputDir(jobName, key)
+	chunks := make([]*OutputChunk, 0)
 
+	var idx OutputIndex
+	pair, err := s.Client.Get(dir, nil)
+	if err == store.ErrKeyNotFound {
+		return chunks, &idx, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(pair.Value, &idx); err != nil {
+		return nil, nil, err
+	}
+
+	for seq := after + 1; seq <= idx.Chunks && len(chunks) < MaxOutputRead; seq++ {
+		res, err := s.Client.Get(fmt.Sprintf("%s/%08d", dir, seq), nil)
+		if err == store.ErrKeyNotFound {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
+		chunks = append(chunks, &OutputChunk{JobName: jobName, ExecutionKey: key, Seq: seq, Data: res.Value})
 	}
+	return chunks, &idx, nil
+}
 
-	return key, nil
+// DeleteOutput removes the output of an execution.
+func (s *Store) DeleteOutput(jobName string, key string) error {
+	dir := s.outputDir(jobName, key)
+	if err := s.Client.DeleteTree(dir); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	// the index, some backends keep it as a key besides the chunks
+	if err := s.Client.Delete(dir); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) outputDir(jobName string, key string) string {
+	return fmt.Sprintf("%s/executions/%s/%s/output", s.keyspace, jobName, key)
 }
 
 // Removes all executions of a job
 func (s *Store) DeleteExecutions(jobName string) error {
+	exs, err := s.GetExecutions(jobName)
+	if err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	for _, ex := range exs {
+		if err := s.reindex(ex, nil); err != nil {
+			return err
+		}
+	}
 	return s.Client.DeleteTree(fmt.Sprintf("%s/executions/%s", s.keyspace, jobName))
 }
 
+// GetRunningExecutions returns the executions of a job which haven't finished, of all jobs without name.
+func (s *Store) GetRunningExecutions(jobName string) ([]*Execution, error) {
+	return queryAll(s, ExecutionQuery{Job: jobName, Status: StateRunning})
+}
+
+// GetUnfinishedShards returns the shards which are still running on a node.
+func (s *Store) GetUnfinishedShards(nodeName string) ([]*Execution, error) {
+	exs, err := queryAll(s, ExecutionQuery{Node: nodeName, Status: StateRunning})
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]*Execution, 0)
+	for _, ex := range exs {
+		if ex.ShardTotal > 0 {
+			shards = append(shards, ex)
+		}
+	}
+	return shards, nil
+}
+
 func (s *Store) DeleteExecutionsByNodeName(nodeName string) error {
 
-	exs, err := s.GetExecutionsAll()
+	exs, err := queryAll(s, ExecutionQuery{Node: nodeName})
 	if err == nil {
 		for _, ex := range exs {
 			key := ex.Key()
@@ -526,17 +1233,13 @@ func (s *Store) DeleteExecutionsByNodeName(nodeName string) error {
 				"execution": ex,
 			}).Debug("store.DeleteExecutionsByNodeName: to detele executions of which node has downed. ")
 
-			if ex.NodeName == nodeName {
-				if ex.Success == false {
-
-					err := s.Client.Delete(fmt.Sprintf("%s/executions/%s/%s", s.keyspace, ex.JobName, key))
-					if err != nil {
-						log.WithFields(log.Fields{
-							"nodeName":  nodeName,
-							"key":       key,
-							"execution": ex,
-						}).Error("store.DeleteExecutionsByNodeName: to detele executions of which node has downed. ")
-					}
+			if ex.Success == false {
+				if err := s.DeleteExecution(ex); err != nil {
+					log.WithFields(log.Fields{
+						"nodeName":  nodeName,
+						"key":       key,
+						"execution": ex,
+					}).Error("store.DeleteExecutionsByNodeName: to detele executions of which node has downed. ")
 				}
 			}
 		}
@@ -544,3 +1247,229 @@ func (s *Store) DeleteExecutionsByNodeName(nodeName string) error {
 
 	return nil
 }
+
+// QueryExecutions returns a page of the executions matching the query, the latest first.
+// The executions are looked up by the most selective index of the query.
+func (s *Store) QueryExecutions(q *ExecutionQuery) (*ExecutionPage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	var refs []executionRef
+	var err error
+
+	switch {
+	case q.Group != 0:
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/group/%s/%019d/", s.keyspace, q.Job, q.Group))
+	case q.Node != "":
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/node/%s/", s.keyspace, q.Node))
+	case q.Status != "" && q.Job != "":
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/status/%s/%s/", s.keyspace, q.Status, q.Job))
+	case q.Status != "":
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/status/%s/", s.keyspace, q.Status))
+	case q.Job != "":
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/job/%s/", s.keyspace, q.Job))
+	default:
+		refs, err = s.listIndex(fmt.Sprintf("%s/index/job/", s.keyspace))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]executionRef, 0, len(refs))
+	for _, ref := range refs {
+		if q.Job != "" && ref.job != q.Job {
+			continue
+		}
+		if q.Cursor != "" && ref.cursor() >= q.Cursor {
+			continue
+		}
+		if t, ok := ref.startedAt(); ok && !q.inRange(t) {
+			continue
+		}
+		candidates = append(candidates, ref)
+	}
+	sort.Slice(candidates, func(i, k int) bool { return candidates[i].cursor() > candidates[k].cursor() })
+
+	page := &ExecutionPage{Executions: make([]*Execution, 0)}
+	limit := q.limit()
+	// only the executions of the page are loaded
+	for i, ref := range candidates {
+		ex, err := s.GetExecution(ref.job, ref.key)
+		// an index may be ahead of a deletion
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !q.Matches(ex) {
+			continue
+		}
+
+		page.Executions = append(page.Executions, ex)
+		if len(page.Executions) == limit {
+			if i < len(candidates)-1 {
+				page.Cursor = ref.cursor()
+			}
+			break
+		}
+	}
+	return page, nil
+}
+
+// queryAll returns all of the executions of the storage matching the query, page by page.
+func queryAll(s Storage, q ExecutionQuery) ([]*Execution, error) {
+	q.Limit = MaxPageSize
+	exs := make([]*Execution, 0)
+	for {
+		page, err := s.QueryExecutions(&q)
+		if err != nil {
+			return nil, err
+		}
+		exs = append(exs, page.Executions...)
+		if page.Cursor == "" {
+			return exs, nil
+		}
+		q.Cursor = page.Cursor
+	}
+}
+
+// listIndex lists the references of an index under a prefix:
+// index/job/<job>/<execution key>
+// index/node/<node>/<execution key>/<job>
+// index/status/<status>/<job>/<execution key>
+// index/group/<job>/<group>/<execution key>
+func (s *Store) listIndex(prefix string) ([]executionRef, error) {
+	res, err := s.Client.List(prefix, nil)
+	if err == store.ErrKeyNotFound {
+		return []executionRef{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]executionRef, 0, len(res))
+	for _, node := range res {
+		path := store.SplitKey(node.Key)
+		if i := len(path) - 4; i >= 0 && path[i] == "index" && path[i+1] == "job" {
+			refs = append(refs, executionRef{job: path[i+2], key: path[i+3]})
+			continue
+		}
+		i := len(path) - 5
+		if i < 0 || path[i] != "index" {
+			continue
+		}
+		switch path[i+1] {
+		case "node":
+			refs = append(refs, executionRef{job: path[i+4], key: path[i+3]})
+		case "status":
+			refs = append(refs, executionRef{job: path[i+3], key: path[i+4]})
+		case "group":
+			refs = append(refs, executionRef{job: path[i+2], key: path[i+4], group: path[i+3]})
+		}
+	}
+	return refs, nil
+}
+
+// indexKeys returns the keys of the indexes of an execution.
+func (s *Store) indexKeys(ex *Execution) []string {
+	if ex == nil {
+		return []string{}
+	}
+
+	key := ex.Key()
+	keys := []string{
+		fmt.Sprintf("%s/index/job/%s/%s", s.keyspace, ex.JobName, key),
+		fmt.Sprintf("%s/index/status/%s/%s/%s", s.keyspace, ex.State(), ex.JobName, key),
+	}
+	if ex.NodeName != "" {
+		keys = append(keys, fmt.Sprintf("%s/index/node/%s/%s/%s", s.keyspace, ex.NodeName, key, ex.JobName))
+	}
+	// the executions which haven't been run don't make the groups of a job
+	if ex.Ran() {
+		keys = append(keys, fmt.Sprintf("%s/index/group/%s/%019d/%s", s.keyspace, ex.JobName, ex.Group, key))
+	}
+	return keys
+}
+
+// reindex replaces the indexes of the former state of an execution with the indexes of its new state,
+// a nil state has no index.
+func (s *Store) reindex(prev *Execution, ex *Execution) error {
+	keys := s.indexKeys(ex)
+	prevKeys := s.indexKeys(prev)
+
+	for _, k := range prevKeys {
+		if StringInSlice(k, keys) {
+			continue
+		}
+		if err := s.Client.Delete(k); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	for _, k := range keys {
+		if StringInSlice(k, prevKeys) {
+			continue
+		}
+		if err := s.Client.Put(k, []byte(ex.JobName), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackGroup records the transition of an execution in the last group of the metadata of its job,
+// so the status of a job is read at once, see JobMetaData.track.
+func (s *Store) trackGroup(prev *Execution, ex *Execution) error {
+	cur := ex
+	if cur == nil {
+		cur = prev
+	}
+	if cur == nil || !cur.Ran() {
+		return nil
+	}
+
+	job, err := s.getJobDefinition(cur.JobName)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.UpdateJobMetadata(cur.JobName, func(m *JobMetaData) bool {
+		return m.track(prev, ex, job.SuccessRule)
+	})
+	return err
+}
+
+// EnsureIndexes builds the indexes of the executions if they haven't been built yet,
+// or have been built by a former version of the indexes.
+func (s *Store) EnsureIndexes() error {
+	marker, err := s.Client.Get(fmt.Sprintf("%s/index/version", s.keyspace), nil)
+	if err == nil && string(marker.Value) == IndexVersion {
+		return nil
+	}
+	return s.Reindex()
+}
+
+// Reindex rebuilds the indexes of all of the executions.
+func (s *Store) Reindex() error {
+	log.Info("store: Rebuilding the indexes of the executions")
+
+	if err := s.Client.DeleteTree(fmt.Sprintf("%s/index", s.keyspace)); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+
+	exs, err := s.GetExecutionsAll()
+	if err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	for _, ex := range exs {
+		if err := s.reindex(nil, ex); err != nil {
+			return err
+		}
+	}
+
+	return s.Client.Put(fmt.Sprintf("%s/index/version", s.keyspace), []byte(IndexVersion), nil)
+}
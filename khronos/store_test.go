@@ -1,27 +1,18 @@
 package khronos
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/abronan/valkeyrie/store"
+	log "github.com/sirupsen/logrus"
 )
 
-var (
-	logLevel = "error"
-	etcdAddr = getEnvWithDefault()
-)
-
-func getEnvWithDefault() string {
-	ea := os.Getenv("khronos_BACKEND_MACHINE")
-	if ea == "" {
-		return "127.0.0.1:2379"
-	}
-	return ea
-}
-
 //go test -v -run=TestStoreJob
 func TestStoreJob(t *testing.T) {
 	store := createTestStore()
@@ -58,17 +49,17 @@ func TestStoreJob(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error getting jobs: %s", err)
 	}
-	if jobs[0].Name != "test" {
+	if jobs[0].Name != testJob.Name {
 		t.Fatalf("expected job name: %s got: %s", testJob.Name, jobs[0].Name)
 	}
 	fmt.Println("Got all jobs in test", jobs)
 
-	if _, err := store.DeleteJob("test"); err != nil {
+	if _, err := store.DeleteJob(testJob.Name); err != nil {
 		t.Fatalf("error deleting job: %s", err)
 	}
 
-	if _, err := store.DeleteJob("test"); err != nil {
-		t.Fatalf("error job deletion should fail: %s", err)
+	if _, err := store.DeleteJob(testJob.Name); err == nil {
+		t.Fatalf("error job deletion should fail")
 	}
 
 }
@@ -106,7 +97,7 @@ func TestStoreProcessor(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error getting processors: %s", err)
 	}
-	if processors[0].NodeName != "server-001" {
+	if processors[0].NodeName != testProcessor.NodeName {
 		t.Fatalf("expected node name: %s got: %s", testProcessor.NodeName, processors[0].NodeName)
 	}
 	fmt.Println("Got all processors in test", processors)
@@ -116,8 +107,8 @@ func TestStoreProcessor(t *testing.T) {
 		t.Fatalf("error deleting processor: %s", err)
 	}
 
-	if _, err := store.DeleteProcessor(testProcessor.Application, addr); err != nil {
-		t.Fatalf("error processor deletion should fail: %s", err)
+	if _, err := store.DeleteProcessor(testProcessor.Application, addr); err == nil {
+		t.Fatalf("error processor deletion should fail")
 	}
 
 }
@@ -152,11 +143,281 @@ func TestStoreExecution(t *testing.T) {
 	}
 }
 
+//go test -v -run=TestStoreJobMetadata
+func TestStoreJobMetadata(t *testing.T) {
+	s := createTestStore()
+
+	job := &Job{Name: "spider", Schedule: "@every 2s", JobType: "rpc", Application: "spider"}
+	if err := s.SetJob(job); err != nil {
+		t.Fatalf("error creating job: %s", err)
+	}
+
+	// the executions finishing together with an edit of the job
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.UpdateJobMetadata(job.Name, func(m *JobMetaData) bool {
+				m.SuccessCount += 1
+				return true
+			})
+			if err != nil {
+				t.Errorf("error updating metadata: %s", err)
+			}
+		}()
+	}
+	edited := &Job{Name: "spider", Schedule: "@every 5s", JobType: "rpc", Application: "spider"}
+	if err := s.SetJob(edited); err != nil {
+		t.Fatalf("error editing job: %s", err)
+	}
+	wg.Wait()
+
+	stored, err := s.GetJob(job.Name)
+	if err != nil {
+		t.Fatalf("error getting job: %s", err)
+	}
+	if stored.Metadata.SuccessCount != 20 || stored.Schedule != "@every 5s" {
+		t.Fatalf("expected 20 successes of the edited job got %d of %s", stored.Metadata.SuccessCount, stored.Schedule)
+	}
+}
+
+//go test -v -run=TestStoreLastGroupStatus
+func TestStoreLastGroupStatus(t *testing.T) {
+	s := createTestStore()
+	a := createTestAgent(s)
+
+	job := &Job{Name: "spider", Schedule: "@every 2s", JobType: "rpc", Application: "spider", Agent: a}
+	if err := s.SetJob(job); err != nil {
+		t.Fatalf("error creating job: %s", err)
+	}
+
+	now := time.Now()
+	ex := &Execution{JobName: job.Name, NodeName: "server-001", Group: now.UnixNano(), StartedAt: now}
+	if _, err := s.SetExecution(ex); err != nil {
+		t.Fatalf("error creating execution: %s", err)
+	}
+	if status := job.Status(); status != Running {
+		t.Fatalf("expected running got %d", status)
+	}
+
+	ex.FinishedAt = time.Now()
+	if _, err := s.SetExecution(ex); err != nil {
+		t.Fatalf("error finishing execution: %s", err)
+	}
+	if status := job.Status(); status != Failed {
+		t.Fatalf("expected failed got %d", status)
+	}
+
+	m, err := s.GetJobMetadata(job.Name)
+	if err != nil {
+		t.Fatalf("error getting metadata: %s", err)
+	}
+	if m.LastGroup != ex.Group || m.Running != 0 {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+}
+
+//go test -v -run=TestStoreQueryExecutions
+func TestStoreQueryExecutions(t *testing.T) {
+	s := createTestStore()
+
+	start := time.Date(2018, 6, 8, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		for _, name := range []string{"spider", "parser"} {
+			started := start.Add(time.Duration(i) * time.Minute)
+			ex := &Execution{
+				JobName:    name,
+				NodeName:   fmt.Sprintf("server-%03d", i%2),
+				Group:      started.UnixNano(),
+				StartedAt:  started,
+				FinishedAt: started.Add(time.Second),
+				Success:    i != 3,
+			}
+			if _, err := s.SetExecution(ex); err != nil {
+				t.Fatalf("error creating execution: %s", err)
+			}
+		}
+	}
+
+	// two by two, the latest first
+	q := &ExecutionQuery{Job: "spider", Limit: 2}
+	seen := make([]time.Time, 0)
+	for {
+		page, err := s.QueryExecutions(q)
+		if err != nil {
+			t.Fatalf("error querying executions: %s", err)
+		}
+		for _, ex := range page.Executions {
+			seen = append(seen, ex.StartedAt)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		q.Cursor = page.Cursor
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 executions got %d", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if !seen[i].Before(seen[i-1]) {
+			t.Fatalf("expected the latest first got %v", seen)
+		}
+	}
+
+	page, err := s.QueryExecutions(&ExecutionQuery{Status: StateFailed})
+	if err != nil || len(page.Executions) != 2 {
+		t.Fatalf("expected 2 failed executions got %v %v", page, err)
+	}
+
+	page, err = s.QueryExecutions(&ExecutionQuery{Job: "parser", Node: "server-000", From: start.Add(time.Minute)})
+	if err != nil || len(page.Executions) != 2 {
+		t.Fatalf("expected 2 executions of parser on server-000 got %v %v", page, err)
+	}
+
+	// the indexes follow the changes of the executions
+	failed := page.Executions[0]
+	failed.Success = false
+	if _, err := s.SetExecution(failed); err != nil {
+		t.Fatalf("error updating execution: %s", err)
+	}
+	if err := s.DeleteExecution(page.Executions[1]); err != nil {
+		t.Fatalf("error deleting execution: %s", err)
+	}
+	page, err = s.QueryExecutions(&ExecutionQuery{Job: "parser", Status: StateFailed})
+	if err != nil || len(page.Executions) != 2 {
+		t.Fatalf("expected 2 failed executions of parser got %v %v", page, err)
+	}
+	page, err = s.QueryExecutions(&ExecutionQuery{Node: "server-000", Job: "parser"})
+	if err != nil || len(page.Executions) != 2 {
+		t.Fatalf("expected 2 executions of parser on server-000 got %v %v", page, err)
+	}
+
+	// the indexes are rebuilt from the executions
+	if err := s.Client.DeleteTree("/khronos-test/index"); err != nil {
+		t.Fatalf("error deleting the indexes: %s", err)
+	}
+	if err := s.EnsureIndexes(); err != nil {
+		t.Fatalf("error building the indexes: %s", err)
+	}
+	page, err = s.QueryExecutions(&ExecutionQuery{Status: StateFailed})
+	if err != nil || len(page.Executions) != 3 {
+		t.Fatalf("expected 3 failed executions got %v %v", page, err)
+	}
+
+	// the indexes of a former version are rebuilt
+	if err := s.Client.DeleteTree("/khronos-test/index/job"); err != nil {
+		t.Fatalf("error deleting the job index: %s", err)
+	}
+	if err := s.Client.Put("/khronos-test/index/version", []byte("1"), nil); err != nil {
+		t.Fatalf("error setting the index version: %s", err)
+	}
+	if err := s.EnsureIndexes(); err != nil {
+		t.Fatalf("error building the indexes: %s", err)
+	}
+	page, err = s.QueryExecutions(&ExecutionQuery{Job: "parser"})
+	if err != nil || len(page.Executions) != 4 {
+		t.Fatalf("expected 4 executions of parser got %v %v", page, err)
+	}
+	page, err = s.QueryExecutions(&ExecutionQuery{From: start.Add(3 * time.Minute)})
+	if err != nil || len(page.Executions) != 4 {
+		t.Fatalf("expected 4 executions from the fourth minute got %v %v", page, err)
+	}
+}
+
+// countingKV counts the reads of the executions.
+type countingKV struct {
+	store.Store
+
+	gets  int
+	lists int
+}
+
+func (kv *countingKV) Get(key string, options *store.ReadOptions) (*store.KVPair, error) {
+	if strings.Contains(key, "/executions/") {
+		kv.gets++
+	}
+	return kv.Store.Get(key, options)
+}
+
+func (kv *countingKV) List(directory string, options *store.ReadOptions) ([]*store.KVPair, error) {
+	if strings.Contains(directory, "/executions") {
+		kv.lists++
+	}
+	return kv.Store.List(directory, options)
+}
+
+//go test -v -run=TestStoreQueryExecutionsPage
+func TestStoreQueryExecutionsPage(t *testing.T) {
+	s := createTestStore()
+
+	start := time.Date(2018, 6, 8, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		started := start.Add(time.Duration(i) * time.Minute)
+		ex := &Execution{JobName: "spider", NodeName: "server-000", Group: started.UnixNano(), StartedAt: started, FinishedAt: started, Success: true}
+		if _, err := s.SetExecution(ex); err != nil {
+			t.Fatalf("error creating execution: %s", err)
+		}
+	}
+
+	kv := &countingKV{Store: s.Client}
+	s.Client = kv
+	for _, q := range []*ExecutionQuery{
+		{Job: "spider", Limit: 5},
+		{From: start.Add(10 * time.Minute), Limit: 5},
+	} {
+		kv.gets, kv.lists = 0, 0
+		page, err := s.QueryExecutions(q)
+		if err != nil || len(page.Executions) != 5 || page.Cursor == "" {
+			t.Fatalf("expected a page of 5 executions got %v %v", page, err)
+		}
+		if kv.lists != 0 || kv.gets != 5 {
+			t.Fatalf("expected the 5 executions of the page read only got %d lists and %d gets", kv.lists, kv.gets)
+		}
+	}
+}
+
+//go test -v -run=TestStoreJobSecretNotLogged
+func TestStoreJobSecretNotLogged(t *testing.T) {
+	s := createTestStore()
+
+	var buf bytes.Buffer
+	level := log.GetLevel()
+	log.SetOutput(&buf)
+	log.SetLevel(log.DebugLevel)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetLevel(level)
+	}()
+
+	if err := s.SetJob(&Job{Name: "deploy", Schedule: "@every 5s", WebhookSecret: "s3cr3t"}); err != nil {
+		t.Fatalf("error setting job: %s", err)
+	}
+	if err := s.SetTemplate(&Template{Name: "deploy", Job: Job{Name: "deploy-${env}", WebhookSecret: "s3cr3t"}}); err != nil {
+		t.Fatalf("error setting template: %s", err)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Fatalf("expected the secret not logged got %s", buf.String())
+	}
+
+	job, err := s.GetJob("deploy")
+	if err != nil {
+		t.Fatalf("error getting job: %s", err)
+	}
+	if job.WebhookSecret != "s3cr3t" {
+		t.Fatalf("expected the secret stored got %q", job.WebhookSecret)
+	}
+}
+
 func createTestStore() *Store {
-	store := NewStore("etcdv3", []string{"127.0.0.1:2379"}, "/khronos-test")
+	store := NewMemoryStore("/khronos-test")
 	return store
 }
 
+func createTestAgent(s Storage) *Agent {
+	return &Agent{store: s, config: &Configuration{}}
+}
+
 func cleanTestKVSpace(s *Store) error {
 	err := s.Client.DeleteTree("/khronos-test")
 	if err != nil && err != store.ErrKeyNotFound {
@@ -164,3 +425,53 @@ func cleanTestKVSpace(s *Store) error {
 	}
 	return nil
 }
+
+//go test -v -run=TestStoreSlots
+func TestStoreSlots(t *testing.T) {
+	s := createTestStore()
+
+	// the agents firing at the same time share the slots
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := make([]int64, 0)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(group int64) {
+			defer wg.Done()
+			ok, err := s.TakeSlot("spider", group, 3)
+			if err != nil {
+				t.Errorf("error taking slot: %s", err)
+			}
+			if ok {
+				mu.Lock()
+				taken = append(taken, group)
+				mu.Unlock()
+			}
+		}(now.UnixNano() + int64(i))
+	}
+	wg.Wait()
+	if len(taken) != 3 {
+		t.Fatalf("expected 3 slots taken got %d", len(taken))
+	}
+
+	if err := s.ReleaseSlot("spider", taken[0]); err != nil {
+		t.Fatalf("error releasing slot: %s", err)
+	}
+	if ok, _ := s.TakeSlot("spider", now.UnixNano()+100, 3); !ok {
+		t.Fatalf("expected the released slot taken")
+	}
+	if ok, _ := s.TakeSlot("spider", now.UnixNano()+101, 3); ok {
+		t.Fatalf("expected no slot left")
+	}
+
+	// a slot of a group which isn't running is taken over after the grace
+	old := now.Add(-2 * SlotGrace).UnixNano()
+	s.ReleaseSlot("spider", taken[1])
+	if ok, _ := s.TakeSlot("spider", old, 3); !ok {
+		t.Fatalf("expected the released slot taken")
+	}
+	if ok, _ := s.TakeSlot("spider", now.UnixNano()+102, 3); !ok {
+		t.Fatalf("expected the slot of the old group taken over")
+	}
+}
@@ -0,0 +1,85 @@
+package khronos
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// templateParam matches a ${key} placeholder of a template.
+var templateParam = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// Template is a job definition whose string fields may hold ${key} placeholders,
+// e.g. a Payload of {"eth": "${coinid}"}, replaced by the parameters of its instances.
+type Template struct {
+	//the template name must be unique in all of templates
+	Name string `json:"name"`
+
+	// a breif description for template
+	Breif string `json:"breif"`
+
+	// The job of the instances, its name may hold placeholders too, e.g. "spider-${coinid}"
+	Job Job `json:"job"`
+
+	// Default values of the parameters
+	Defaults map[string]string `json:"defaults"`
+}
+
+// Validate checks the name of the template.
+func (t *Template) Validate() error {
+	if t.Name == "" || strings.Contains(t.Name, "/") {
+		return fmt.Errorf("template: invalid name '%s'", t.Name)
+	}
+	return nil
+}
+
+// Instantiate returns the job of the template with its placeholders replaced by the parameters,
+// named after the template job unless a name is given.
+func (t *Template) Instantiate(name string, params map[string]string) (*Job, error) {
+	values := make(map[string]string)
+	for k, v := range t.Defaults {
+		values[k] = v
+	}
+	for k, v := range params {
+		values[k] = v
+	}
+
+	data, err := json.Marshal(&t.Job)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]string, 0)
+	data = templateParam.ReplaceAllFunc(data, func(m []byte) []byte {
+		key := string(templateParam.FindSubmatch(m)[1])
+		v, ok := values[key]
+		if !ok {
+			if !StringInSlice(key, missing) {
+				missing = append(missing, key)
+			}
+			return m
+		}
+		// the value goes into a JSON string
+		quoted, _ := json.Marshal(v)
+		return quoted[1 : len(quoted)-1]
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template: '%s' misses the parameters %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+
+	// the state of an instance is its own
+	job.Metadata = JobMetaData{}
+	job.IsDone = false
+	if name != "" {
+		job.Name = name
+	}
+	job.Template = t.Name
+	job.TemplateParams = params
+	return &job, nil
+}
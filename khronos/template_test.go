@@ -0,0 +1,112 @@
+package khronos
+
+import (
+	"testing"
+)
+
+//go test -v -run=TestTemplateInstantiate
+func TestTemplateInstantiate(t *testing.T) {
+	tmpl := &Template{
+		Name: "spider",
+		Job: Job{
+			Name:        "spider-${coin}",
+			Schedule:    "@every ${interval}",
+			JobType:     "rpc",
+			Application: "spider",
+			Payload:     map[string]string{"eth": "${coinid}"},
+		},
+		Defaults: map[string]string{"interval": "5s"},
+	}
+	if err := tmpl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]string{"coin": "eth", "coinid": `coin"id-1`}
+	job, err := tmpl.Instantiate("", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Name != "spider-eth" || job.Schedule != "@every 5s" || job.Payload["eth"] != `coin"id-1` {
+		t.Fatalf("unexpected instance %+v", job)
+	}
+	if job.Template != "spider" || job.TemplateParams["coin"] != "eth" {
+		t.Fatalf("expected the template and its params in the instance got %s, %v", job.Template, job.TemplateParams)
+	}
+	if err := job.Validate(); err != nil {
+		t.Fatalf("expected a valid instance got: %s", err)
+	}
+	// the template is left as it is
+	if tmpl.Job.Payload["eth"] != "${coinid}" {
+		t.Fatalf("expected the template unchanged got %v", tmpl.Job.Payload)
+	}
+
+	job, err = tmpl.Instantiate("btc-spider", map[string]string{"coin": "btc", "coinid": "coinid-2", "interval": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Name != "btc-spider" || job.Schedule != "@every 1m" {
+		t.Fatalf("unexpected instance %+v", job)
+	}
+
+	if _, err := tmpl.Instantiate("", map[string]string{"coin": "eth"}); err == nil {
+		t.Fatalf("expected an error of a missing parameter")
+	}
+}
+
+//go test -v -run=TestUpdateTemplateInstances
+func TestUpdateTemplateInstances(t *testing.T) {
+	a := createTestAgent(createTestStore())
+	tmpl := &Template{
+		Name: "spider",
+		Job: Job{
+			Name:        "spider-${coin}",
+			Schedule:    "@every 5s",
+			JobType:     "rpc",
+			Application: "spider",
+		},
+	}
+	if err := a.store.SetTemplate(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	for _, coin := range []string{"eth", "btc", "ltc"} {
+		job, err := tmpl.Instantiate("", map[string]string{"coin": coin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch coin {
+		case "btc":
+			job.Disabled = true
+		case "ltc":
+			job.ManagedBy = "apply"
+		}
+		if err := a.store.SetJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tmpl.Job.Schedule = "@every 1m"
+	if err := a.store.SetTemplate(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.UpdateTemplateInstances(tmpl); n != 2 {
+		t.Fatalf("expected 2 updated instances got %d", n)
+	}
+
+	for name, want := range map[string]struct {
+		schedule string
+		disabled bool
+	}{
+		"spider-eth": {"@every 1m", false},
+		"spider-btc": {"@every 1m", true},
+		"spider-ltc": {"@every 5s", false},
+	} {
+		job, err := a.store.GetJob(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Schedule != want.schedule || job.Disabled != want.disabled {
+			t.Fatalf("expected %s with %s disabled %v got %s disabled %v",
+				name, want.schedule, want.disabled, job.Schedule, job.Disabled)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package khronos
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobTypes are the known types of job.
+var JobTypes = []string{"shell", "rpc", "http"}
+
+// reservedDirs are the directories of the keyspace used by khronos itself, they can't be watched by jobs.
+var reservedDirs = []string{"jobs", "executions", "processors", "schedules", "queue", "calendars", "templates", "idempotency", "index", "metadata", "slots"}
+
+// FieldError tells why a field of a job is invalid.
+type FieldError struct {
+	Field string `json:"field"`
+
+	Message string `json:"message"`
+}
+
+// ValidationError holds all of the invalid fields of a job.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+	}
+	return "invalid job: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the required fields and the values of the job,
+// it returns a *ValidationError listing every invalid field.
+func (j *Job) Validate() error {
+	e := &ValidationError{}
+
+	if j.Name == "" {
+		e.add("name", "is required")
+	} else if strings.Contains(j.Name, "/") {
+		e.add("name", "must not contain '/'")
+	}
+
+	if j.Schedule == "" {
+		if j.Watch == "" {
+			e.add("schedule", "is required unless the job has a watch")
+		}
+	} else if _, err := j.CronSchedule(); err != nil {
+		e.add("schedule", "%s", err)
+	}
+
+	if j.Watch != "" {
+		dir := strings.SplitN(strings.Trim(j.Watch, "/"), "/", 2)[0]
+		if dir == "" || StringInSlice(dir, reservedDirs) {
+			e.add("watch", "'%s' isn't allowed, the directories %s are reserved", j.Watch, strings.Join(reservedDirs, ", "))
+		}
+	}
+
+	if j.JobType == "" {
+		e.add("job_type", "is required")
+	} else if !StringInSlice(j.JobType, JobTypes) {
+		e.add("job_type", "unknown job type '%s', expected one of %s", j.JobType, strings.Join(JobTypes, ", "))
+	} else if j.JobType == "http" && j.HTTPProperties.URL == "" {
+		e.add("http_properties.url", "is required for the http job type")
+	}
+
+	if j.Application == "" {
+		e.add("Application", "is required")
+	}
+
+	concurrencies := []string{ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace, ConcurrencyQueue}
+	if j.Concurrency != "" && !StringInSlice(j.Concurrency, concurrencies) {
+		e.add("concurrency", "unknown concurrency '%s', expected one of %s", j.Concurrency, strings.Join(concurrencies, ", "))
+	}
+	if j.MaxConcurrent < 0 {
+		e.add("max_concurrent", "must not be negative")
+	}
+
+	targets := []string{TargetOne, TargetAll, TargetN}
+	if j.Target != "" && !StringInSlice(j.Target, targets) {
+		e.add("target", "unknown target '%s', expected one of %s", j.Target, strings.Join(targets, ", "))
+	}
+	if j.Target == TargetN && j.TargetCount < 1 {
+		e.add("target_count", "must be at least 1 for the n target")
+	}
+
+	rules := []string{SuccessAll, SuccessAny, SuccessQuorum}
+	if j.SuccessRule != "" && !StringInSlice(j.SuccessRule, rules) {
+		e.add("success_rule", "unknown success rule '%s', expected one of %s", j.SuccessRule, strings.Join(rules, ", "))
+	}
+
+	if j.Shards < 0 {
+		e.add("shards", "must not be negative")
+	}
+	if len(j.ShardParams) > j.Shards {
+		e.add("shard_params", "has %d parameters for %d shards", len(j.ShardParams), j.Shards)
+	}
+
+	misfires := []string{MisfireSkip, MisfireFireOnce, MisfireFireAll}
+	if j.Misfire != "" && !StringInSlice(j.Misfire, misfires) {
+		e.add("misfire", "unknown misfire policy '%s', expected one of %s", j.Misfire, strings.Join(misfires, ", "))
+	}
+	if j.MisfireLimit < 0 {
+		e.add("misfire_limit", "must not be negative")
+	}
+	if j.StartingDeadline < 0 {
+		e.add("starting_deadline", "must not be negative")
+	}
+
+	if j.Jitter != "" {
+		if d, err := time.ParseDuration(j.Jitter); err != nil || d < 0 {
+			e.add("jitter", "invalid duration '%s', e.g. 30s", j.Jitter)
+		}
+	}
+
+	if j.StallTimeout < 0 {
+		e.add("stall_timeout", "must not be negative")
+	}
+
+	if j.Retention != nil {
+		if err := j.Retention.Validate(); err != nil {
+			e.Errors = append(e.Errors, err.(*ValidationError).Errors...)
+		}
+	}
+
+	if !j.StartAt.IsZero() && !j.EndAt.IsZero() && !j.EndAt.After(j.StartAt) {
+		e.add("end_at", "must be after start_at")
+	}
+
+	if err := j.NodeSelector.Validate(); err != nil {
+		e.add("node_selector", "%s", err)
+	}
+
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
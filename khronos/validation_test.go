@@ -0,0 +1,103 @@
+package khronos
+
+import (
+	"testing"
+	"time"
+)
+
+//go test -v -run=TestValidateJob
+func TestValidateJob(t *testing.T) {
+	valid := &Job{
+		Name:        "valid",
+		Schedule:    "30 9 * * MON-FRI",
+		JobType:     "rpc",
+		Application: "spider",
+		Concurrency: ConcurrencyForbid,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid job got: %s", err)
+	}
+
+	invalid := &Job{
+		Name:        "a/b",
+		Schedule:    "61 * * * *",
+		JobType:     "ftp",
+		Concurrency: "sometimes",
+	}
+	err := invalid.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a *ValidationError got: %v", err)
+	}
+
+	fields := make([]string, 0)
+	for _, fe := range verr.Errors {
+		fields = append(fields, fe.Field)
+	}
+	for _, f := range []string{"name", "schedule", "job_type", "Application", "concurrency"} {
+		if !StringInSlice(f, fields) {
+			t.Fatalf("expected an error of %s got: %v", f, verr.Errors)
+		}
+	}
+}
+
+//go test -v -run=TestDescribeSchedule
+func TestDescribeSchedule(t *testing.T) {
+	cases := map[string]string{
+		"30 9 * * MON-FRI":         "at 09:30:00, on Monday through Friday",
+		"* * * * * *":              "every second, every day",
+		"*/10 * * * * *":           "every 10 seconds, every day",
+		"0 * * * * *":              "at second 0 of every minute, every day",
+		"0 0 */2 * * *":            "at minute 0 of every 2 hours, every day",
+		"*/15 9-17 * * *":          "every 15 minutes of hours 9 through 17, every day",
+		"0,30 * * * *":             "at minutes 0 and 30 of every hour, every day",
+		"0 0 L * *":                "at 00:00:00, on the last day of the month",
+		"0 0 15W * *":              "at 00:00:00, on the weekday nearest day 15 of the month",
+		"0 18 * * 5#3":             "at 18:00:00, on the third Friday of the month",
+		"0 18 * * FRIL":            "at 18:00:00, on the last Friday of the month",
+		"0 0 1 * SUN":              "at 00:00:00, on day 1 of the month or on Sunday",
+		"0 0 1 JAN,JUL *":          "at 00:00:00, on day 1 of the month, in January and July",
+		"@daily":                   "at 00:00:00, every day",
+		"@every 5s":                "every 5s",
+		"@oneway":                  "once, as soon as it's scheduled",
+		"@at 2018-06-08T10:00:00Z": "once at 2018-06-08T10:00:00Z",
+	}
+	for spec, expected := range cases {
+		got, err := DescribeSchedule(spec)
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err)
+		}
+		if got != expected {
+			t.Fatalf("%s: expected '%s' got '%s'", spec, expected, got)
+		}
+	}
+
+	if _, err := DescribeSchedule("* * *"); err == nil {
+		t.Fatalf("expected an error of an invalid schedule")
+	}
+}
+
+//go test -v -run=TestNextRuns
+func TestNextRuns(t *testing.T) {
+	sched, err := ParseSchedule("0 0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2018, 6, 8, 10, 0, 0, 0, time.Local)
+	runs := NextRuns(sched, from, 5)
+	if len(runs) != 5 {
+		t.Fatalf("expected 5 runs got %d", len(runs))
+	}
+	for i, r := range runs {
+		expected := time.Date(2018, 6, 9+i, 9, 0, 0, 0, time.Local)
+		if !r.Equal(expected) {
+			t.Fatalf("expected %s got %s", expected, r)
+		}
+	}
+
+	at, _ := ParseSchedule("@at 2018-06-08T11:00:00Z")
+	if runs := NextRuns(at, from.UTC(), 5); len(runs) != 1 {
+		t.Fatalf("expected 1 run of a one shot got %d", len(runs))
+	}
+}
@@ -21,6 +21,21 @@ func main() {
 				Ui: ui,
 			}, nil
 		},
+		"export": func() (cli.Command, error) {
+			return &khronos.ExportCommand{
+				Ui: ui,
+			}, nil
+		},
+		"import": func() (cli.Command, error) {
+			return &khronos.ImportCommand{
+				Ui: ui,
+			}, nil
+		},
+		"apply": func() (cli.Command, error) {
+			return &khronos.ApplyCommand{
+				Ui: ui,
+			}, nil
+		},
 	}
 
 	exitStatus, err := c.Run()
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -16,6 +17,9 @@ import (
 
 type WorkerRPCServer struct {
 	rc *WorkerRPCClient
+
+	mux     sync.Mutex
+	cancels map[string]context.CancelFunc
 }
 
 type WorkerRPCClient struct {
@@ -40,7 +44,8 @@ func main() {
 
 	//set up rpcserver
 	rs := &WorkerRPCServer{
-		rc: rc,
+		rc:      rc,
+		cancels: make(map[string]context.CancelFunc),
 	}
 
 	//start up
@@ -77,21 +82,55 @@ func (rs *WorkerRPCServer) listenRPC() {
 func (rs *WorkerRPCServer) ExecutionDo(ctx context.Context, args *khronos.Execution, reply *khronos.RPCReply) error {
 	//start to process this job
 	reply.Ack = reply.Ack + 1
-	go rs.Process(args)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.mux.Lock()
+	rs.cancels[args.Key()] = cancel
+	rs.mux.Unlock()
+
+	go rs.Process(ctx, args)
+
+	return nil
+}
+
+//ExecutionCancel stop to handle a job which has been replaced
+func (rs *WorkerRPCServer) ExecutionCancel(ctx context.Context, args *khronos.Execution, reply *khronos.RPCReply) error {
+	rs.mux.Lock()
+	cancel, ok := rs.cancels[args.Key()]
+	delete(rs.cancels, args.Key())
+	rs.mux.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	reply.Ack = reply.Ack + 1
+	reply.Success = ok
 	return nil
 }
 
 //Process is customized
 //if finished then recall ExecutionDone
-func (rs *WorkerRPCServer) Process(args *khronos.Execution) {
+func (rs *WorkerRPCServer) Process(ctx context.Context, args *khronos.Execution) {
+	//the output is streamed to khronos while processing
+	output := rs.rc.NewOutputWriter(args)
+
 	var done = make(chan bool, 1)
 	go func() {
 		i := 0
 		for {
+			select {
+			case <-ctx.Done():
+				// cancelled, khronos has recorded the execution as failed
+				return
+			default:
+			}
+
 			i++
 			fmt.Println("fmt-Process job:", i)
 			log.Debug("log-Process job:", i)
+			fmt.Fprintln(output, "Process job:", i)
+			rs.rc.ExecutionProgress(args, float64(i)*100/6, "processing", map[string]int64{"steps": int64(i)})
 			if i > 5 {
 				//if done well put true in done
 				done <- true
@@ -100,7 +139,15 @@ func (rs *WorkerRPCServer) Process(args *khronos.Execution) {
 		}
 	}()
 
-	rs.rc.ExecutionDone(args, <-done)
+	select {
+	case ok := <-done:
+		rs.rc.ExecutionDone(args, ok)
+	case <-ctx.Done():
+	}
+
+	rs.mux.Lock()
+	delete(rs.cancels, args.Key())
+	rs.mux.Unlock()
 }
 
 //Pong do nothing but just reply to ping
@@ -119,6 +166,7 @@ func (rc *WorkerRPCClient) ServNodeReg() {
 		Port:              9002,
 		MaxExecutionLimit: 10,
 		Status:            true,
+		Labels:            map[string]string{"region": "hk", "gpu": "false"},
 	}
 
 	replay := &khronos.RPCReply{}
@@ -142,16 +190,23 @@ func (rc *WorkerRPCClient) MakeJob() {
 		Disabled:    false,
 		Concurrency: "forbid",
 		Application: "spider",
+		NodeSelector: &khronos.Selector{
+			MatchLabels: map[string]string{"region": "hk"},
+		},
 	}
 
-	replay := &khronos.RPCReply{}
+	replay := &khronos.JobReply{}
 
 	err := rc.xclient.Call(context.Background(), "MakeJob", testJob, replay)
 	if err != nil {
 		log.Error("failed to create a job: ", err)
 	}
 
-	log.Debug("MakeJob ack: %d, success: %t", replay.Ack, replay.Success)
+	for _, fe := range replay.Errors {
+		log.Errorf("invalid job, %s: %s", fe.Field, fe.Message)
+	}
+
+	log.Debug("MakeJob ack: %d, success: %t, schedule: %s, next runs: %v", replay.Ack, replay.Success, replay.Description, replay.NextRuns)
 
 }
 
@@ -167,6 +222,61 @@ func (rc *WorkerRPCClient) ExecutionDone(args *khronos.Execution, done bool) {
 	fmt.Println("work done", args, replay)
 }
 
+//ExecutionProgress reports the progress of an execution to khronos, it's a heartbeat of the execution too,
+//it returns false once khronos has finished the execution, e.g. as stalled.
+func (rc *WorkerRPCClient) ExecutionProgress(args *khronos.Execution, percent float64, message string, counters map[string]int64) bool {
+	progress := &khronos.ExecutionProgress{
+		JobName:      args.JobName,
+		ExecutionKey: args.Key(),
+		Percent:      percent,
+		Message:      message,
+		Counters:     counters,
+	}
+	replay := &khronos.RPCReply{}
+
+	err := rc.xclient.Call(context.Background(), "ExecutionProgress", progress, replay)
+	if err != nil {
+		log.Error("failed to report progress: ", err)
+		return true
+	}
+	return replay.Success
+}
+
+//OutputWriter streams the output of an execution to khronos by AppendOutput, chunk by chunk
+type OutputWriter struct {
+	rc *WorkerRPCClient
+	ex *khronos.Execution
+}
+
+//NewOutputWriter returns a writer of the output of the execution
+func (rc *WorkerRPCClient) NewOutputWriter(ex *khronos.Execution) *OutputWriter {
+	return &OutputWriter{rc: rc, ex: ex}
+}
+
+//Write sends the data in chunks of at most khronos.MaxOutputChunk bytes
+func (w *OutputWriter) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i += khronos.MaxOutputChunk {
+		end := i + khronos.MaxOutputChunk
+		if end > len(p) {
+			end = len(p)
+		}
+
+		chunk := &khronos.OutputChunk{
+			JobName:      w.ex.JobName,
+			ExecutionKey: w.ex.Key(),
+			Data:         p[i:end],
+		}
+		replay := &khronos.RPCReply{}
+
+		err := w.rc.xclient.Call(context.Background(), "AppendOutput", chunk, replay)
+		if err != nil {
+			log.Error("failed to append output: ", err)
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
 func SetXClient() client.XClient {
 	d := client.NewMultipleServersDiscovery([]*client.KVPair{{Key: *khronosRPCAddr}})
 	xclient := client.NewXClient("khronos", client.Failover, client.RoundRobin, d, client.DefaultOption)